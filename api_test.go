@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// apiStatus is the part of the broker's /api/status answer the tests look at.
+type apiStatus struct {
+	Evolving bool
+	Paused   bool
+	Turn     int
+	Alive    int
+}
+
+// callAPI makes a request to the broker's HTTP API and returns the status code and body.
+func callAPI(t *testing.T, method string, path string, body []byte) (int, []byte) {
+	request, err := http.NewRequest(method, "http://127.0.0.1:8030"+path, bytes.NewReader(body))
+	util.Check(err)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	answer, err := ioutil.ReadAll(response.Body)
+	util.Check(err)
+	return response.StatusCode, answer
+}
+
+// apiFinished waits for the run started through the API to finish and returns its last status.
+func apiFinished(t *testing.T) apiStatus {
+	var status apiStatus
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		code, body := callAPI(t, "GET", "/api/status", nil)
+		if code != http.StatusOK {
+			t.Fatalf("expected the status, got %d %s", code, body)
+		}
+		util.Check(json.Unmarshal(body, &status))
+		if !status.Evolving {
+			return status
+		}
+	}
+	t.Fatal("expected the run to finish within 30s")
+	return status
+}
+
+// TestAPI starts runs over the broker's HTTP API from a PGM image and an RLE pattern, checks the snapshots
+// afterwards, and pauses, resumes and quits a long run.
+func TestAPI(t *testing.T) {
+	t.Run("pgm", func(t *testing.T) {
+		image, err := ioutil.ReadFile("images/64x64.pgm")
+		util.Check(err)
+		code, body := callAPI(t, "POST", "/api/runs?turns=100&threads=4", image)
+		if code != http.StatusAccepted {
+			t.Fatalf("expected the run to start, got %d %s", code, body)
+		}
+		if status := apiFinished(t); status.Turn != 100 {
+			t.Fatalf("expected the run to finish on turn 100, got %d", status.Turn)
+		}
+
+		code, snapshot := callAPI(t, "GET", "/api/snapshot", nil)
+		if code != http.StatusOK {
+			t.Fatalf("expected a snapshot, got %d %s", code, snapshot)
+		}
+		dir, err := ioutil.TempDir("", "gol-api")
+		util.Check(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "snapshot.pgm")
+		util.Check(ioutil.WriteFile(path, snapshot, 0644))
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100}
+		assertEqualBoard(t, readAliveCells(path, 64, 64), readAliveCells("check/images/64x64x100.pgm", 64, 64), p)
+	})
+
+	t.Run("rle", func(t *testing.T) {
+		glider := "#N Glider\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n"
+		code, body := callAPI(t, "POST", "/api/runs?turns=4&width=16&height=16", []byte(glider))
+		if code != http.StatusAccepted {
+			t.Fatalf("expected the run to start, got %d %s", code, body)
+		}
+		apiFinished(t)
+		_, snapshot := callAPI(t, "GET", "/api/snapshot", nil)
+		// After 4 turns the glider has moved one cell right and down
+		moved := []string{"..#", "...#", ".###"}
+		rows := strings.SplitN(string(snapshot), "\n", 4)
+		if len(rows) < 4 || rows[1] != "16 16" {
+			t.Fatalf("expected a 16x16 PGM, got %q", rows)
+		}
+		cells := []byte(rows[3])
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				alive := y >= 1 && y < 4 && x < len(moved[y-1]) && moved[y-1][x] == '#'
+				if (cells[y*16+x] == 255) != alive {
+					t.Fatalf("expected cell %d,%d alive to be %v", x, y, alive)
+				}
+			}
+		}
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		image, err := ioutil.ReadFile("images/512x512.pgm")
+		util.Check(err)
+		code, body := callAPI(t, "POST", "/api/runs?turns=100000000", image)
+		if code != http.StatusAccepted {
+			t.Fatalf("expected the run to start, got %d %s", code, body)
+		}
+		if code, body := callAPI(t, "POST", "/api/runs?turns=1", image); code != http.StatusConflict {
+			t.Fatalf("expected a second run to be refused, got %d %s", code, body)
+		}
+		time.Sleep(500 * time.Millisecond)
+
+		var paused, later apiStatus
+		code, body = callAPI(t, "POST", "/api/pause", nil)
+		if code != http.StatusOK {
+			t.Fatalf("expected the run to pause, got %d %s", code, body)
+		}
+		util.Check(json.Unmarshal(body, &paused))
+		time.Sleep(300 * time.Millisecond)
+		_, body = callAPI(t, "GET", "/api/status", nil)
+		util.Check(json.Unmarshal(body, &later))
+		if !later.Paused || later.Turn != paused.Turn {
+			t.Fatalf("expected the run to stay paused on turn %d, got %+v", paused.Turn, later)
+		}
+
+		if code, body := callAPI(t, "POST", "/api/resume", nil); code != http.StatusOK {
+			t.Fatalf("expected the run to resume, got %d %s", code, body)
+		}
+		if code, body := callAPI(t, "POST", "/api/quit", nil); code != http.StatusOK {
+			t.Fatalf("expected the run to quit, got %d %s", code, body)
+		}
+		if status := apiFinished(t); status.Turn < paused.Turn || status.Turn >= 100000000 {
+			t.Fatalf("expected the run to stop part way, got turn %d", status.Turn)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if code, _ := callAPI(t, "GET", "/api/runs", nil); code != http.StatusMethodNotAllowed {
+			t.Errorf("expected GET /api/runs to be refused with 405, got %d", code)
+		}
+		if code, _ := callAPI(t, "POST", "/api/runs?turns=1", []byte("not an image")); code != http.StatusBadRequest {
+			t.Errorf("expected a bad image to be refused with 400, got %d", code)
+		}
+		glider := []byte("x = 3, y = 3\nbob$2bo$3o!\n")
+		if code, _ := callAPI(t, "POST", "/api/runs?turns=1&width=1000000&height=1000000", glider); code != http.StatusBadRequest {
+			t.Errorf("expected a board larger than any image to be refused with 400, got %d", code)
+		}
+		huge := []byte("P5\n1000000 1000000\n255\n")
+		if code, _ := callAPI(t, "POST", "/api/runs?turns=1", huge); code != http.StatusBadRequest {
+			t.Errorf("expected a PGM larger than any image to be refused with 400, got %d", code)
+		}
+		if code, _ := callAPI(t, "POST", "/api/resume", nil); code != http.StatusConflict {
+			t.Errorf("expected resuming a run that is not paused to be refused with 409, got %d", code)
+		}
+	})
+}
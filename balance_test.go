@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPartition runs 512x512 for 100 turns and checks the last turn was split into strips covering every row once,
+// with each worker that was sent tiles timed.
+func TestPartition(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100, Threads: 4}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range events {
+	}
+
+	client, err := transport.Dial(transport.Gob, "127.0.0.1:8030")
+	util.Check(err)
+	defer client.Close()
+	res := stubs.PartitionResponse{}
+	util.Check(client.Call(stubs.PartitionHandler, stubs.Empty{}, &res))
+	if res.Turn != 100 || len(res.Strips) == 0 {
+		t.Fatalf("expected the partition of turn 100, got %+v", res)
+	}
+
+	row, shares := 0, 0.0
+	for _, s := range res.Strips {
+		t.Logf("%s rows %d-%d, %d active tiles, share %.2f, took %v", s.Worker, s.StartRow, s.EndRow, s.Active, s.Share, s.Latency)
+		if s.StartRow != row || s.EndRow < s.StartRow {
+			t.Errorf("expected a strip starting at row %d, got %+v", row, s)
+		}
+		if s.Active > 0 && s.Latency <= 0 {
+			t.Errorf("expected the call to %s to have been timed, got %+v", s.Worker, s)
+		}
+		row = s.EndRow
+		shares += s.Share
+	}
+	if row != 512/64 {
+		t.Errorf("expected the strips to end at tile row %d, got %d", 512/64, row)
+	}
+	if math.Abs(shares-1) > 0.05 {
+		t.Errorf("expected the shares to add up to 1, got %v", shares)
+	}
+}
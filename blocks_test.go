@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestBlocks runs the check images split into blocks instead of strips, then checks the last run really was
+// split by columns as well as rows.
+func TestBlocks(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Threads = 4
+			p.Decomposition = stubs.BlocksDecomposition
+			t.Run(fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns), func(t *testing.T) {
+				runGolden(t, p)
+			})
+		}
+	}
+
+	client, err := transport.Dial(transport.Gob, "127.0.0.1:8030")
+	util.Check(err)
+	defer client.Close()
+	res := stubs.PartitionResponse{}
+	util.Check(client.Call(stubs.PartitionHandler, stubs.Empty{}, &res))
+	columns := make(map[string]bool)
+	for _, s := range res.Strips {
+		t.Logf("%s rows %d-%d, columns %d-%d, %d active tiles", s.Worker, s.StartRow, s.EndRow, s.StartCol, s.EndCol, s.Active)
+		columns[fmt.Sprint(s.StartCol, s.EndCol)] = true
+	}
+	if len(res.Strips) > 1 && len(columns) < 2 {
+		t.Errorf("expected the board split by columns too, got %+v", res.Strips)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCompression runs 512x512 for 10 turns and checks packing the tiles sent less than sending them raw.
+func TestCompression(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 10, Threads: 4}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range events {
+	}
+
+	client, err := transport.Dial(transport.Gob, "127.0.0.1:8030")
+	util.Check(err)
+	defer client.Close()
+	res := stubs.BandwidthResponse{}
+	util.Check(client.Call(stubs.BandwidthHandler, stubs.Empty{}, &res))
+	if res.Turn != 10 || res.TotalRawBytes == 0 {
+		t.Fatalf("expected bytes counted up to turn 10, got %+v", res)
+	}
+	t.Logf("last turn %d of %d bytes, run %d of %d bytes", res.SentBytes, res.RawBytes, res.TotalSentBytes, res.TotalRawBytes)
+	if res.SentBytes >= res.RawBytes || res.TotalSentBytes >= res.TotalRawBytes {
+		t.Errorf("packed tiles were no smaller than raw ones: %+v", res)
+	}
+}
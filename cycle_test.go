@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestCycle checks that the 512x512 image is found to repeat every 2 turns and that skipping
+// to the last turn gives the same alive cells as count_test.go expects for that turn.
+func TestCycle(t *testing.T) {
+	p := gol.Params{
+		Turns:       100000001,
+		Threads:     8,
+		ImageWidth:  512,
+		ImageHeight: 512,
+		OnCycle:     "skip",
+	}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+
+	period := 0
+	for event := range events {
+		switch e := event.(type) {
+		case gol.CycleDetected:
+			period = e.Period
+		case gol.FinalTurnComplete:
+			if e.CompletedTurns != p.Turns {
+				t.Errorf("expected %v completed turns, got %v", p.Turns, e.CompletedTurns)
+			}
+			if len(e.Alive) != 5567 {
+				t.Errorf("At turn %v expected 5567 alive cells, got %v instead", e.CompletedTurns, len(e.Alive))
+			}
+		}
+	}
+	if period != 2 {
+		t.Errorf("expected a period of 2, got %v", period)
+	}
+}
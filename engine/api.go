@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// maxImageBytes is the largest image a run can be started with over HTTP. Boards started or saved over HTTP
+// can have no more cells than that either.
+const maxImageBytes = 64 << 20
+
+// api is what the HTTP API knows about runs, kept apart from g.Mu so the status can be read while a run is
+// paused. Only runs paused through the API can be resumed through it.
+type api struct {
+	mu sync.Mutex
+	// starting is set from a run being started through the API until it has finished
+	starting bool
+	paused   bool
+	// err is why the last run started through the API failed
+	err string
+	// pausing is held while pausing or resuming, so the two never overlap
+	pausing sync.Mutex
+}
+
+// apiRun is the answer to starting a run.
+type apiRun struct {
+	Session string `json:"session"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Turns   int    `json:"turns"`
+}
+
+// apiStatus is how far the run has got, or the last one once it has finished.
+type apiStatus struct {
+	Session  string `json:"session"`
+	Evolving bool   `json:"evolving"`
+	Paused   bool   `json:"paused"`
+	Turn     int    `json:"turn"`
+	Turns    int    `json:"turns"`
+	Alive    int    `json:"alive"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Standby  bool   `json:"standby"`
+	Error    string `json:"error,omitempty"`
+}
+
+// statusError is an error answered with a status other than 500.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+// serveAPI adds a small HTTP JSON API next to the RPC calls, for dashboards and scripts that cannot speak gob:
+//
+//	POST /api/runs      starts a run, the body being a PGM or RLE image and the parameters in the query
+//	GET  /api/status    reports how far the run has got
+//	POST /api/pause     pauses the run
+//	POST /api/resume    resumes a run paused through the API
+//	GET  /api/snapshot  downloads the board as a PGM image
+//	POST /api/quit      stops the run, keeping the board
+func (g *GOLWorker) serveAPI() {
+	transport.Handle("/api/runs", g.endpoint("POST", stubs.Operator, "start runs", g.apiStart))
+	transport.Handle("/api/status", g.endpoint("GET", stubs.Observer, "see runs", g.apiStatus))
+	transport.Handle("/api/pause", g.endpoint("POST", stubs.Operator, "pause runs", g.apiPause))
+	transport.Handle("/api/resume", g.endpoint("POST", stubs.Operator, "unpause runs", g.apiResume))
+	transport.Handle("/api/snapshot", g.endpoint("GET", stubs.Observer, "see the world", g.apiSnapshot))
+	transport.Handle("/api/quit", g.endpoint("POST", stubs.Operator, "quit runs", g.apiQuit))
+}
+
+// endpoint checks the method and the caller's role before handing a request on, answering any error as JSON.
+func (g *GOLWorker) endpoint(method string, role string, action string, handle func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := stubs.Caller{Role: transport.CallerRole(r)}
+		err := caller.Check(role, action)
+		if err != nil {
+			err = statusError{http.StatusForbidden, err}
+		} else if r.Method != method {
+			err = statusError{http.StatusMethodNotAllowed, fmt.Errorf("%s must be %sed", r.URL.Path, method)}
+		} else {
+			err = handle(w, r)
+		}
+		if err == nil {
+			return
+		}
+		status := http.StatusInternalServerError
+		if e, ok := err.(statusError); ok {
+			status = e.status
+		}
+		logging.Warn("api call failed", "path", r.URL.Path, "from", r.RemoteAddr, "status", status, "err", err)
+		writeJSON(w, status, struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// replicaState returns the state of the run after its last turn, which does not wait for g.Mu.
+func (g *GOLWorker) replicaState() (stubs.ReplicateResponse, *tiles.World) {
+	g.Replica.mu.Lock()
+	defer g.Replica.mu.Unlock()
+	return g.Replica.state, g.Replica.current()
+}
+
+// status reports on the run without waiting for g.Mu.
+func (g *GOLWorker) status() apiStatus {
+	state, world := g.replicaState()
+	g.API.mu.Lock()
+	defer g.API.mu.Unlock()
+	s := apiStatus{
+		Session:  state.Run.Session,
+		Evolving: state.Evolving || g.API.starting,
+		Paused:   g.API.paused,
+		Turn:     state.Turn,
+		Turns:    state.Run.Turn,
+		Width:    state.Run.ImageWidth,
+		Height:   state.Run.ImageHeight,
+		Standby:  g.standingBy(),
+		Error:    g.API.err,
+	}
+	if world != nil {
+		s.Alive = world.AliveCount()
+	}
+	return s
+}
+
+// apiStart starts a run in the background and answers straight away. The query takes the same parameters as the
+// controller's flags: turns, which has to be given, and threads, engine, onCycle, decomposition, turnsPerCall,
+// verify and unbounded. RLE patterns are placed in the top left corner of a board width by height, which
+// defaults to the size of the pattern.
+func (g *GOLWorker) apiStart(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImageBytes))
+	if err != nil {
+		return statusError{http.StatusBadRequest, err}
+	}
+	world, err := readImage(data, query)
+	if err != nil {
+		return statusError{http.StatusBadRequest, err}
+	}
+	req := stubs.EvolveWorldRequest{
+		Tiles:         world.List(),
+		Width:         world.Width,
+		Height:        world.Height,
+		ImageWidth:    world.Width,
+		ImageHeight:   world.Height,
+		Unbounded:     world.Unbounded,
+		Engine:        query.Get("engine"),
+		OnCycle:       query.Get("onCycle"),
+		Session:       logging.NewSession(),
+		Decomposition: query.Get("decomposition"),
+	}
+	req.Caller = stubs.Caller{Role: transport.CallerRole(r)}
+	if query.Get("turns") == "" {
+		return statusError{http.StatusBadRequest, errors.New("turns has to be given")}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+		def   int
+	}{{"turns", &req.Turn, 0}, {"threads", &req.Threads, 8}, {"turnsPerCall", &req.TurnsPerCall, 1}} {
+		if *param.value, err = intParam(query, param.name, param.def); err != nil {
+			return statusError{http.StatusBadRequest, err}
+		}
+	}
+	if req.Verify, err = boolParam(query, "verify"); err != nil {
+		return statusError{http.StatusBadRequest, err}
+	}
+	if req.TurnsPerCall > tiles.Size {
+		return statusError{http.StatusBadRequest, fmt.Errorf("at most %d turns can be worked out per call", tiles.Size)}
+	}
+	if g.standingBy() {
+		return statusError{http.StatusConflict, fmt.Errorf("this broker is a standby for %s, start runs there", g.Primary)}
+	}
+
+	state, _ := g.replicaState()
+	g.API.mu.Lock()
+	if g.API.starting || state.Evolving {
+		g.API.mu.Unlock()
+		return statusError{http.StatusConflict, errors.New("a run is already going on")}
+	}
+	g.API.starting = true
+	g.API.err = ""
+	g.API.mu.Unlock()
+
+	go func() {
+		err := g.EvolveWorld(req, &stubs.EvolveResponse{})
+		g.API.mu.Lock()
+		defer g.API.mu.Unlock()
+		g.API.starting = false
+		if err != nil {
+			g.API.err = err.Error()
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, apiRun{req.Session, req.ImageWidth, req.ImageHeight, req.Turn})
+	return nil
+}
+
+func (g *GOLWorker) apiStatus(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, g.status())
+	return nil
+}
+
+func (g *GOLWorker) apiPause(w http.ResponseWriter, r *http.Request) error {
+	g.API.pausing.Lock()
+	defer g.API.pausing.Unlock()
+	if err := g.pause(transport.CallerRole(r)); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, g.status())
+	return nil
+}
+
+// pause pauses the run through the API, holding g.Mu until resume. The run may finish while waiting for g.Mu,
+// so whether it is still going on is only checked once g.Mu is held. Called with g.API.pausing held.
+func (g *GOLWorker) pause(role string) error {
+	errNoRun := statusError{http.StatusConflict, errors.New("there is no run going on to pause")}
+	g.API.mu.Lock()
+	paused := g.API.paused
+	g.API.mu.Unlock()
+	if paused {
+		return errNoRun
+	}
+	caller := stubs.Empty{}
+	caller.SetRole(role)
+	if err := g.Pause(caller, &stubs.Empty{}); err != nil {
+		return err
+	}
+	if !g.Evolving {
+		g.Mu.Unlock()
+		return errNoRun
+	}
+	g.API.mu.Lock()
+	g.API.paused = true
+	g.API.mu.Unlock()
+	return nil
+}
+
+func (g *GOLWorker) apiResume(w http.ResponseWriter, r *http.Request) error {
+	g.API.pausing.Lock()
+	defer g.API.pausing.Unlock()
+	if err := g.resume(transport.CallerRole(r)); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, g.status())
+	return nil
+}
+
+// resume unpauses a run paused through the API. Called with g.API.pausing held.
+func (g *GOLWorker) resume(role string) error {
+	g.API.mu.Lock()
+	paused := g.API.paused
+	g.API.paused = false
+	g.API.mu.Unlock()
+	if !paused {
+		return statusError{http.StatusConflict, errors.New("the run was not paused through the API")}
+	}
+	caller := stubs.Empty{}
+	caller.SetRole(role)
+	return g.Unpause(caller, &stubs.Empty{})
+}
+
+// apiSnapshot sends the board after the last turn as a PGM image, the turn going in the X-Gol-Turn header.
+// Browsers save it under the name the controller would have written it as.
+func (g *GOLWorker) apiSnapshot(w http.ResponseWriter, r *http.Request) error {
+	state, world := g.replicaState()
+	if world == nil {
+		return statusError{http.StatusNotFound, errors.New("nothing has been run yet")}
+	}
+	bounds := world.Bounds()
+	if err := checkSize(bounds.Width, bounds.Height); err != nil {
+		return statusError{http.StatusUnprocessableEntity, err}
+	}
+	w.Header().Set("Content-Type", "image/x-portable-graymap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%dx%dx%d.pgm\"", bounds.Width, bounds.Height, state.Turn))
+	w.Header().Set("X-Gol-Turn", strconv.Itoa(state.Turn))
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "P5\n")
+	if world.Unbounded {
+		fmt.Fprintf(buffered, "# offset %d %d\n", bounds.X, bounds.Y)
+	}
+	fmt.Fprintf(buffered, "%d %d\n255\n", bounds.Width, bounds.Height)
+	// Rows are written out as they are made, stopping once the client has gone
+	world.EachRow(bounds, func(row []byte) error {
+		_, err := buffered.Write(row)
+		return err
+	})
+	return buffered.Flush()
+}
+
+// apiQuit stops the run, resuming it first if it was paused through the API.
+func (g *GOLWorker) apiQuit(w http.ResponseWriter, r *http.Request) error {
+	g.API.pausing.Lock()
+	defer g.API.pausing.Unlock()
+	s := g.status()
+	if !s.Evolving {
+		return statusError{http.StatusConflict, errors.New("there is no run going on to quit")}
+	}
+	if s.Paused {
+		if err := g.resume(transport.CallerRole(r)); err != nil {
+			return err
+		}
+	}
+	caller := stubs.Empty{}
+	caller.SetRole(transport.CallerRole(r))
+	if err := g.QuitServer(caller, &stubs.Empty{}); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, g.status())
+	return nil
+}
+
+// intParam reads a whole number from the query, def when it is not given.
+func intParam(query url.Values, name string, def int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s has to be a whole number, got %q", name, value)
+	}
+	return n, nil
+}
+
+// boolParam reads a flag from the query, false when it is not given.
+func boolParam(query url.Values, name string) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s has to be true or false, got %q", name, value)
+	}
+	return b, nil
+}
+
+// readImage reads a PGM image, or an RLE pattern when it does not start like a PGM.
+func readImage(data []byte, query url.Values) (*tiles.World, error) {
+	unbounded, err := boolParam(query, "unbounded")
+	if err != nil {
+		return nil, err
+	}
+	var world *tiles.World
+	if bytes.HasPrefix(data, []byte("P5")) {
+		world, err = readPGM(data)
+	} else {
+		world, err = readRLE(data, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	world.Unbounded = unbounded
+	return world, nil
+}
+
+// readPGM reads a binary PGM image, any cell that is not 0 being alive.
+func readPGM(data []byte) (*tiles.World, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	// The header is the magic number, width, height and maxval, with comments allowed in between
+	var header []int
+	token := ""
+	for len(header) < 4 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, errors.New("the PGM header is cut short")
+		}
+		switch {
+		case b == '#':
+			if _, err := reader.ReadString('\n'); err != nil {
+				return nil, errors.New("the PGM header is cut short")
+			}
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
+			if token == "P5" {
+				header = append(header, 0)
+			} else if token != "" {
+				n, err := strconv.Atoi(token)
+				if err != nil {
+					return nil, fmt.Errorf("unexpected %q in the PGM header", token)
+				}
+				header = append(header, n)
+			}
+			token = ""
+		default:
+			token += string(b)
+		}
+	}
+	width, height, maxval := header[1], header[2], header[3]
+	if width <= 0 || height <= 0 || maxval != 255 {
+		return nil, fmt.Errorf("expected a PGM with a maxval of 255, got %dx%d with %d", width, height, maxval)
+	}
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+	// Only a row is held at a time, going straight into tiles
+	world := tiles.New(width, height)
+	row := make([]byte, width)
+	for y := 0; y < height; y++ {
+		if _, err := io.ReadFull(reader, row); err != nil {
+			return nil, fmt.Errorf("the PGM ends at row %d of %d", y, height)
+		}
+		for x, cell := range row {
+			if cell != 0 {
+				row[x] = 255
+			}
+		}
+		world.SetRow(y, row)
+	}
+	return world, nil
+}
+
+// checkSize refuses boards with more cells than the largest image a run can be started with.
+func checkSize(width int, height int) error {
+	if height > 0 && width > maxImageBytes/height {
+		return fmt.Errorf("a %dx%d board has more than the %d cells allowed", width, height, maxImageBytes)
+	}
+	return nil
+}
+
+// readRLE reads a pattern in the run length encoded format most Life software uses. Only the usual B3/S23 rule
+// can be run.
+func readRLE(data []byte, query url.Values) (*tiles.World, error) {
+	var header, body []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case header == nil && strings.HasPrefix(line, "x"):
+			header = strings.Split(line, ",")
+		default:
+			body = append(body, line)
+		}
+	}
+	if header == nil {
+		return nil, errors.New("expected a PGM image or an RLE pattern with an x = ..., y = ... header")
+	}
+	sizes := make(map[string]int)
+	for _, field := range header {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected %q in the RLE header", field)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key == "rule" {
+			if rule := strings.ToUpper(value); rule != "B3/S23" && rule != "23/3" {
+				return nil, fmt.Errorf("only the B3/S23 rule can be run, not %s", value)
+			}
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected %q in the RLE header", field)
+		}
+		sizes[key] = n
+	}
+
+	width, err := intParam(query, "width", sizes["x"])
+	if err != nil {
+		return nil, err
+	}
+	height, err := intParam(query, "height", sizes["y"])
+	if err != nil {
+		return nil, err
+	}
+	if width < sizes["x"] || height < sizes["y"] || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("a %dx%d pattern does not fit on a %dx%d board", sizes["x"], sizes["y"], width, height)
+	}
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	world := tiles.New(width, height)
+	x, y, count := 0, 0, 0
+	for _, c := range strings.Join(body, "") {
+		switch {
+		case c >= '0' && c <= '9':
+			count = count*10 + int(c-'0')
+			continue
+		case c == '!':
+			return world, nil
+		case c == ' ' || c == '\t':
+			continue
+		}
+		if count == 0 {
+			count = 1
+		}
+		switch c {
+		case 'b':
+			x += count
+		case '$':
+			x = 0
+			y += count
+		default:
+			// Any other letter is an alive cell, some writers use more than o
+			if x+count > width || y >= height {
+				return nil, fmt.Errorf("the pattern goes past its %dx%d size", width, height)
+			}
+			for i := 0; i < count; i++ {
+				world.Set(x+i, y, 255)
+			}
+			x += count
+		}
+		count = 0
+	}
+	return world, nil
+}
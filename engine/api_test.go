@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// TestPause checks pausing through the API when the run has just finished leaves g.Mu free, and that a run
+// paused through the API can be resumed.
+func TestPause(t *testing.T) {
+	g := fakeBroker(&fakeWorker{})
+	// The replica still says the run is going on, as it does just after it finishes
+	g.Replica.state.Evolving = true
+	if err := g.pause(stubs.Operator); err == nil {
+		t.Errorf("expected nothing to pause once the run has finished")
+	}
+	locked := make(chan bool)
+	go func() {
+		g.Mu.Lock()
+		g.Mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("expected g.Mu to be left free")
+	}
+
+	g.Evolving = true
+	if err := g.pause(stubs.Operator); err != nil {
+		t.Fatalf("expected the run to pause, got %v", err)
+	}
+	if !g.status().Paused {
+		t.Errorf("expected the run to be paused")
+	}
+	if err := g.pause(stubs.Operator); err == nil {
+		t.Errorf("expected a paused run not to be paused again")
+	}
+	if err := g.resume(stubs.Operator); err != nil {
+		t.Errorf("expected the run to resume, got %v", err)
+	}
+	g.Mu.Lock()
+	g.Mu.Unlock()
+}
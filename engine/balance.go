@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/metrics"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
+// minShare is the smallest share of the work a worker is given, as a fraction of an even share,
+// so even a very slow worker keeps being measured and can earn its share back.
+const minShare = 0.02
+
+var shareGauge = metrics.NewGauge("gol_worker_share", "Fraction of the active tiles the worker is being given.", "worker")
+
+// region is the tiles from startRow up to endRow and startCol up to endCol that one worker works out.
+type region struct {
+	startRow int
+	endRow   int
+	startCol int
+	endCol   int
+}
+
+// contains reports whether a tile is in the region.
+func (r region) contains(c tiles.Coord) bool {
+	return c.Y >= r.startRow && c.Y < r.endRow && c.X >= r.startCol && c.X < r.endCol
+}
+
+// borders reports whether a tile is in the ring of tiles around the region, which is its halo.
+func (r region) borders(world *tiles.World, c tiles.Coord) bool {
+	if r.contains(c) {
+		return false
+	}
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if r.contains(world.Neighbour(c, dx, dy)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resetShares gives every connected worker the same share and forgets how long they took, before anything has
+// been measured.
+func (g *GOLWorker) resetShares() {
+	g.Took = make([]estimate, len(g.Workers))
+	g.Shares = make([]float64, len(g.Workers))
+	for i := range g.Shares {
+		g.Shares[i] = 1 / float64(len(g.Workers))
+	}
+}
+
+// grid picks how many bands of rows and workers across each band to split the board into. Strips are a band
+// per worker. Blocks use the grid whose blocks have the shortest edges, which is the halo sent with them.
+func grid(workers int, rows int, cols int, decomposition string) (int, int) {
+	if decomposition != stubs.BlocksDecomposition || workers == 0 {
+		return workers, 1
+	}
+	bands, edge := workers, math.Inf(1)
+	for r := 1; r <= workers; r++ {
+		if workers%r != 0 {
+			continue
+		}
+		c := workers / r
+		e := float64(rows)/float64(r) + float64(cols)/float64(c)
+		if e < edge {
+			bands, edge = r, e
+		}
+	}
+	return bands, workers / bands
+}
+
+// partition splits the world between workers with the given shares, giving each about its share of the active
+// tiles. The rows are split into bands first, each getting the shares of the workers in it, then each band is
+// split by columns. Whole tile rows and columns are handed out so every worker gets a rectangle.
+// Workers do not own tiles: on an unbounded world the rectangles are cut afresh every turn out of the rows and
+// columns around the live area, so a tile moves to another worker whenever the area grows or the shares change.
+func partition(world *tiles.World, active map[tiles.Coord]bool, decomposition string, shares []float64) []region {
+	firstRow, lastRow := world.RowRange()
+	firstCol, lastCol := world.ColRange()
+	bands, across := grid(len(shares), lastRow-firstRow, lastCol-firstCol, decomposition)
+
+	perRow := make([]int, lastRow-firstRow)
+	for c := range active {
+		if c.Y >= firstRow && c.Y < lastRow {
+			perRow[c.Y-firstRow]++
+		}
+	}
+	bandShares := make([]float64, bands)
+	for i, share := range shares {
+		bandShares[i/across] += share
+	}
+	rowBounds := split(perRow, firstRow, bandShares)
+
+	regions := make([]region, len(shares))
+	for b := 0; b < bands; b++ {
+		startRow, endRow := rowBounds[b], rowBounds[b+1]
+		colBounds := []int{firstCol, lastCol}
+		if across > 1 {
+			perCol := make([]int, lastCol-firstCol)
+			for c := range active {
+				if c.Y >= startRow && c.Y < endRow && c.X >= firstCol && c.X < lastCol {
+					perCol[c.X-firstCol]++
+				}
+			}
+			colBounds = split(perCol, firstCol, shares[b*across:(b+1)*across])
+		}
+		for k := 0; k < across; k++ {
+			regions[b*across+k] = region{startRow, endRow, colBounds[k], colBounds[k+1]}
+		}
+	}
+	return regions
+}
+
+// split hands out the lines starting at first, tile rows or columns holding counts active tiles each, by share.
+// It returns where each share starts followed by where the last one ends. A line is taken while more than half
+// of it fits in what that share should get.
+func split(counts []int, first int, shares []float64) []int {
+	total, sum := 0, 0.0
+	for _, count := range counts {
+		total += count
+	}
+	for _, share := range shares {
+		sum += share
+	}
+	bounds := []int{first}
+	line, done, wanted := 0, 0, 0.0
+	for i, share := range shares {
+		if sum > 0 {
+			wanted += share / sum * float64(total)
+		}
+		if i == len(shares)-1 {
+			line = len(counts)
+		}
+		for line < len(counts) && float64(done)+float64(counts[line])/2 < wanted {
+			done += counts[line]
+			line++
+		}
+		bounds = append(bounds, first+line)
+	}
+	return bounds
+}
+
+// rebalance moves work from the workers that took longest last turn to those that finished first. Each share
+// is scaled by the square root of how far the worker was from the average, which settles instead of overshooting
+// when calls have a fixed cost on top of the time per tile. A latency of 0 is a worker that was sent nothing,
+// it finished first so its share doubles, otherwise it could never be measured again. A negative latency is a
+// failed call and halves the share.
+func (g *GOLWorker) rebalance(latencies []time.Duration) {
+	var sum time.Duration
+	measured := 0
+	for _, latency := range latencies {
+		if latency > 0 {
+			sum += latency
+			measured++
+		}
+	}
+	if measured == 0 {
+		return
+	}
+	mean := float64(sum) / float64(measured)
+	total := 0.0
+	for i, latency := range latencies {
+		switch {
+		case latency > 0:
+			g.Shares[i] *= math.Sqrt(mean / float64(latency))
+		case latency == 0:
+			g.Shares[i] *= 2
+		default:
+			g.Shares[i] /= 2
+		}
+		total += g.Shares[i]
+	}
+	floor := minShare / float64(len(g.Shares))
+	for i := range g.Shares {
+		g.Shares[i] = math.Max(g.Shares[i]/total, floor)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// TestSplit checks every line is handed to exactly one share, whatever the counts and shares.
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		first  int
+		shares []float64
+	}{
+		{"even", []int{1, 1, 1, 1, 1, 1, 1, 1}, 0, []float64{0.25, 0.25, 0.25, 0.25}},
+		{"uneven", []int{5, 0, 0, 9, 1, 1, 0, 3}, 0, []float64{0.5, 0.1, 0.4}},
+		{"offset", []int{2, 2, 2, 2}, -3, []float64{1, 1}},
+		{"quiet", []int{0, 0, 0, 0, 0}, 0, []float64{0.3, 0.3, 0.4}},
+		{"more shares than lines", []int{4, 4}, 0, []float64{0.2, 0.2, 0.2, 0.2, 0.2}},
+		{"no shares left", []int{1, 2, 3}, 0, []float64{0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bounds := split(test.counts, test.first, test.shares)
+			if len(bounds) != len(test.shares)+1 {
+				t.Fatalf("expected %d bounds, got %v", len(test.shares)+1, bounds)
+			}
+			if bounds[0] != test.first || bounds[len(bounds)-1] != test.first+len(test.counts) {
+				t.Fatalf("expected the bounds to run from %d to %d, got %v", test.first, test.first+len(test.counts), bounds)
+			}
+			for i := 1; i < len(bounds); i++ {
+				if bounds[i] < bounds[i-1] {
+					t.Fatalf("expected the bounds to never go back, got %v", bounds)
+				}
+			}
+		})
+	}
+
+	// Shares get about the active tiles they ask for
+	bounds := split([]int{1, 1, 1, 1, 1, 1, 1, 1}, 0, []float64{0.75, 0.25})
+	if bounds[1] != 6 {
+		t.Errorf("expected a 3:1 split of 8 even lines to end the first share at 6, got %v", bounds)
+	}
+}
+
+// TestRebalance has one of four workers take four times as long per tile as the others, and checks its share
+// shrinks towards a quarter of theirs and stays there with every worker finishing at about the same time.
+func TestRebalance(t *testing.T) {
+	g := &GOLWorker{Workers: make([]transport.Client, 4)}
+	g.resetShares()
+	cost := []float64{1, 1, 1, 4}
+	latencies := make([]time.Duration, len(cost))
+	measure := func() {
+		for i, share := range g.Shares {
+			// Every call costs a millisecond on top of the time for its tiles
+			latencies[i] = time.Millisecond + time.Duration(share*cost[i]*float64(100*time.Millisecond))
+		}
+	}
+
+	var settled []float64
+	for turn := 0; turn < 100; turn++ {
+		measure()
+		g.rebalance(latencies)
+		if turn == 49 {
+			settled = append([]float64(nil), g.Shares...)
+		}
+	}
+
+	want := 1.0 / 13
+	if math.Abs(g.Shares[3]-want) > 0.01 {
+		t.Errorf("expected the slow worker's share to settle near %.3f, got %v", want, g.Shares)
+	}
+	for i := range g.Shares {
+		if math.Abs(g.Shares[i]-settled[i]) > 0.001 {
+			t.Errorf("expected the shares to stay put once settled, went from %v to %v", settled, g.Shares)
+			break
+		}
+	}
+	measure()
+	fastest, slowest := latencies[0], latencies[0]
+	for _, latency := range latencies {
+		if latency < fastest {
+			fastest = latency
+		}
+		if latency > slowest {
+			slowest = latency
+		}
+	}
+	if float64(slowest) > 1.05*float64(fastest) {
+		t.Errorf("expected every worker to take about as long, got %v", latencies)
+	}
+
+	// A failed call halves the share and a worker sent nothing gets its share doubled
+	g.resetShares()
+	g.rebalance([]time.Duration{-1, 0, time.Millisecond, time.Millisecond})
+	if !(g.Shares[0] < g.Shares[2] && g.Shares[2] < g.Shares[1]) {
+		t.Errorf("expected a failed worker to lose share and an idle one to gain it, got %v", g.Shares)
+	}
+}
@@ -9,23 +9,119 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/hashlife"
+	"uk.ac.bris.cs/gameoflife/logging"
 	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/tracing"
+	"uk.ac.bris.cs/gameoflife/transport"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 var wg sync.WaitGroup
 var kill = make(chan bool)
 
+// cycleHistory is how many past turns are remembered when looking for a repeating world.
+const cycleHistory = 64
+
+// turnAttempts is how many times a turn is tried before the run fails, waiting turnRetry in between so workers
+// that went away have time to answer heartbeats again.
+const turnAttempts = 5
+const turnRetry = time.Second
+
+// pastWorld is a world from an earlier turn kept to spot the board repeating itself.
+type pastWorld struct {
+	turn  int
+	hash  uint64
+	world *tiles.World
+}
+
 type GOLWorker struct {
-	World   [][]byte
-	Turn    int
-	Mu      sync.Mutex
-	Quit    bool
-	Workers []*rpc.Client
+	World   *tiles.World
+	Life    *hashlife.Universe
+	Changed map[tiles.Coord]bool
+	// Hash is the world's hash, kept up to date from the tiles that change each turn
+	Hash    uint64
+	History []pastWorld
+	Period  int
+	// PeriodTurn is the turn the repeat was noticed on
+	PeriodTurn int
+	Turn       int
+	Mu         sync.Mutex
+	Running    sync.Mutex
+	Quit       bool
+	Workers    []transport.Client
+	// Addresses is where each of the workers was dialled
+	Addresses []string
+	// Encodings is the tile encoding agreed with each worker, empty for unpacked
+	Encodings []string
+	// Lost is set for workers whose last call failed, they are dialled again before being sent another turn
+	Lost []bool
+	// Health is which workers are answering, found out from heartbeats and the calls made for turns
+	Health health
+	Bytes  stubs.BandwidthResponse
+	// Transport is the protocol used to talk to the workers
+	Transport string
+	// Compression is the tile encoding to ask the workers for, or none
+	Compression string
+	// Flips holds the cells flipped each turn for the controller to show
+	Flips flipLog
+	// Evolving is set while a run is going on
+	Evolving bool
+	Rate     turnRate
+	// Session names the current run in the logs, Log adds it to every line
+	Session string
+	Log     *logging.Logger
+	// Trace keeps the spans of every turn when the run is traced
+	Trace tracing.Recorder
+	// Shares is the fraction of the active tiles each worker is given, adjusted every turn by how long it took
+	Shares []float64
+	// Partition is how the last turn was split between the workers, into strips or blocks as Decomposition says
+	Partition     []stubs.WorkerStrip
+	Decomposition string
+	// TurnsPerCall is how many turns the workers work out each time they are called
+	TurnsPerCall int
+	// Speculate is how many times longer than the median a strip can take before it is sent to another worker too,
+	// Took how long each worker's calls usually take
+	Speculate float64
+	Took      []estimate
+	// Verify has every strip worked out by two workers, Divergences are where they disagreed this run
+	Verify      bool
+	Divergences divergenceLog
+	// Replica is the state of the run after the last turn, for a standby broker to carry on from
+	Replica replica
+	// Primary is set on a standby to the broker it follows, Promoted is closed once it has taken over
+	Primary  string
+	Promoted chan bool
+	// API is what the HTTP API knows about runs it started and paused
+	API api
+}
+
+// strip is what one worker sent back for a turn, with the bytes the tiles took both ways.
+// part is the worker it was meant for and by the one that worked it out.
+type strip struct {
+	part      int
+	by        int
+	tiles     []*tiles.Tile
+	flipped   []util.Cell
+	changed   []tiles.Coord
+	rawBytes  int
+	sentBytes int
+	// called is set when the worker was sent anything, err when that failed
+	called bool
+	err    error
+	// active is how many tiles it was asked to work out and latency how long the call took
+	active  int
+	latency time.Duration
+	// lastChanged is set when several turns were worked out at once
+	lastChanged []tiles.Coord
+	// spans are the broker's own for the strip followed by the worker's, which have no node set yet
+	spans []tracing.Span
 }
 
-//reads worker addresses line by line
+// reads worker addresses line by line
 func ReadFileLines(filePath string) []string {
 
 	file, err := os.Open(filePath)
@@ -51,160 +147,666 @@ func ReadFileLines(filePath string) []string {
 	return lines
 }
 
-func worker(id int, world [][]byte, results chan<- [][]byte, p gol.Params, client *rpc.Client, threads int) {
-	var heightDiff = float32(p.ImageHeight) / float32(threads)
-
-	// Calculate StartRow and EndRow based on the thread ID
-	startRow := int(float32(id) * heightDiff)
-	endRow := int(float32(id+1) * heightDiff)
-
-	// Ensure that EndRow does not exceed the total number of rows
-	if endRow > p.ImageHeight {
-		endRow = p.ImageHeight
+// worker sends one strip of the active tiles to worker id, filling in the rows and tiles of the request it is given.
+// part is which worker the strip was meant for, normally the same one.
+func worker(id int, part int, world *tiles.World, active map[tiles.Coord]bool, results chan<- strip, client transport.Client, encoding string, r region, worldReq stubs.WorldReq) {
+	// Only the tiles that can change are worked out, quiet strips are not sent at all.
+	// Over several turns the halo changes as well and has to be worked out alongside the region.
+	var toCalculate []tiles.Coord
+	inside := 0
+	for c := range active {
+		if r.contains(c) {
+			toCalculate = append(toCalculate, c)
+			inside++
+		} else if worldReq.Turns > 1 && r.borders(world, c) {
+			toCalculate = append(toCalculate, c)
+		}
+	}
+	if inside == 0 {
+		results <- strip{part: part, by: id}
+		return
 	}
 
-	worldReq := stubs.WorldReq{
-		World:    world,
-		StartRow: startRow,
-		EndRow:   endRow,
-		Width:    p.ImageWidth,
-		Height:   p.ImageHeight,
+	start := time.Now()
+	around := world.Around(toCalculate)
+	if worldReq.Turns > 1 {
+		// Only the cells within Turns of the region are needed from the tiles around it
+		var halo []*tiles.Tile
+		for _, t := range around {
+			c := tiles.Coord{X: t.X, Y: t.Y}
+			if r.contains(c) {
+				halo = append(halo, t)
+			} else if within := world.Within(c, r.contains, worldReq.Turns); len(within) > 0 {
+				if cropped := t.Crop(within); cropped != nil {
+					halo = append(halo, cropped)
+				}
+			}
+		}
+		around = halo
 	}
+	worldReq.Tiles = around
+	worldReq.Active = toCalculate
+	worldReq.StartRow = r.startRow
+	worldReq.EndRow = r.endRow
+	worldReq.StartCol = r.startCol
+	worldReq.EndCol = r.endCol
+
+	result := strip{part: part, by: id, rawBytes: tiles.RawSize(around), called: true, active: inside}
+	if encoding != "" {
+		packed, err := tiles.Pack(around, encoding)
+		if err == nil {
+			worldReq.Tiles = nil
+			worldReq.Packed = packed
+			worldReq.Encoding = encoding
+		}
+	}
+	result.sentBytes = len(worldReq.Packed) + tiles.RawSize(worldReq.Tiles)
 
 	//create a response
-	worldRes := &stubs.WorldRes{
-		World: [][]byte{},
-	}
+	worldRes := &stubs.WorldRes{}
 
+	callStart := time.Now()
 	err := client.Call(stubs.WorldHandler, worldReq, worldRes)
 	if err != nil {
-		print(err)
+		result.err = err
+	}
+	callEnd := time.Now()
+	result.latency = callEnd.Sub(callStart)
+
+	result.tiles = worldRes.Tiles
+	result.flipped = worldRes.Flipped
+	result.changed = worldRes.Changed
+	result.lastChanged = worldRes.LastChanged
+	result.sentBytes += len(worldRes.Packed) + tiles.RawSize(worldRes.Tiles) + 8*len(worldRes.Flipped)
+	if worldReq.Encoding != "" {
+		result.tiles, err = tiles.Unpack(worldRes.Packed, worldReq.Encoding)
+		if err != nil && result.err == nil {
+			result.err = err
+		}
+	}
+	// Without deltas every changed tile would have come back whole
+	result.rawBytes += len(result.changed) * tiles.TileBytes
+
+	if worldReq.Trace != "" {
+		lane := id + 1
+		result.spans = []tracing.Span{
+			{Name: "pack", Trace: worldReq.Trace, Node: tracing.Broker, Lane: lane, Start: start, Duration: callStart.Sub(start)},
+			{Name: "call", Trace: worldReq.Trace, Node: tracing.Broker, Lane: lane, Start: callStart, Duration: callEnd.Sub(callStart),
+				Args: map[string]int{"sentBytes": result.sentBytes}},
+			tracing.Since("unpack", worldReq.Trace, tracing.Broker, lane, callEnd),
+		}
+		result.spans = append(result.spans, tracing.Align(worldRes.Spans, callStart, callEnd)...)
 	}
 
-	results <- worldRes.World
+	results <- result
 	return
 }
 
+// connectWorkers dials every worker listed in workers.txt, dropping connections left from a previous run.
+func (g *GOLWorker) connectWorkers() {
+	for _, client := range g.Workers {
+		client.Close()
+	}
+	g.Workers = nil
+	g.Addresses = nil
+	g.Encodings = nil
+	g.Lost = nil
+
+	workerPorts := ReadFileLines("workers.txt")
+	g.Log.Debug("read workers.txt", "workers", strings.Join(workerPorts, ","))
+	for _, detail := range workerPorts {
+		client, err := transport.Dial(g.Transport, detail)
+		g.Health.record(detail, err)
+		if err == nil {
+			encoding := g.negotiate(client)
+			g.Workers = append(g.Workers, client)
+			g.Addresses = append(g.Addresses, detail)
+			g.Encodings = append(g.Encodings, encoding)
+			g.Lost = append(g.Lost, false)
+			g.Log.Info("connected to worker", "worker", detail, "encoding", encoding)
+		} else {
+			g.Log.Warn("could not connect to worker", "worker", detail, "err", err)
+		}
+	}
+	g.resetShares()
+	g.Health.connect(g.Addresses)
+	g.Log.Info("workers connected", "connected", len(g.Workers), "listed", len(workerPorts))
+}
+
+// negotiate picks the tile encoding to use with a worker. The one asked for with -compression is used if the
+// worker has it, otherwise the best one both ends know. Workers too old to answer are sent tiles unpacked.
+func (g *GOLWorker) negotiate(client transport.Client) string {
+	if g.Compression == "" || g.Compression == "none" {
+		return ""
+	}
+	res := stubs.EncodingsResponse{}
+	err := client.Call(stubs.EncodingsHandler, stubs.Empty{}, &res)
+	if err != nil {
+		return ""
+	}
+	for _, wanted := range append([]string{g.Compression}, tiles.Encodings...) {
+		for _, encoding := range res.Encodings {
+			if encoding == wanted {
+				return encoding
+			}
+		}
+	}
+	return ""
+}
+
 func (g *GOLWorker) EvolveWorld(req stubs.EvolveWorldRequest, res *stubs.EvolveResponse) (err error) {
+	if err = req.Check(stubs.Operator, "start runs"); err != nil {
+		return
+	}
+	if req.TurnsPerCall > tiles.Size {
+		return fmt.Errorf("at most %d turns can be worked out per call", tiles.Size)
+	}
+	if g.standingBy() {
+		return fmt.Errorf("this broker is a standby for %s, start runs there", g.Primary)
+	}
+	// A run that has just been quit may still be finishing its last turn
+	g.Running.Lock()
+	defer g.Running.Unlock()
+
+	world := tiles.FromList(req.ImageWidth, req.ImageHeight, req.Tiles)
+	world.Unbounded = req.Unbounded
+	return g.run(req, world, 0, nil, res)
+}
+
+// run evolves the world from the given turn to the one asked for in req, carrying on with any divergences already
+// found when a standby takes a run over. Called with g.Running held.
+func (g *GOLWorker) run(req stubs.EvolveWorldRequest, world *tiles.World, turn int, divergences []stubs.Divergence, res *stubs.EvolveResponse) (err error) {
+	g.Mu.Lock()
+	if req.Session == "" {
+		req.Session = logging.NewSession()
+	}
+	g.Session = req.Session
+	g.Log = logging.With("session", g.Session)
+	g.Log.Info("run started", "width", req.ImageWidth, "height", req.ImageHeight, "turns", req.Turn, "from", turn, "engine", req.Engine, "unbounded", req.Unbounded)
 	g.Quit = false
-	g.World = req.World
+	g.World = world
 	p := gol.Params{
 		Turns:       req.Turn,
 		Threads:     req.Threads,
 		ImageWidth:  req.ImageWidth,
 		ImageHeight: req.ImageHeight,
 	}
-	g.Turn = 0
-
-	//set up client connection
-	//global list of clients
-	workerPorts := ReadFileLines("workers.txt")
-	fmt.Println(workerPorts)
-	for _, detail := range workerPorts {
-		client, err := rpc.Dial("tcp", detail)
-		if err == nil {
-			g.Workers = append(g.Workers, client)
+	g.Turn = turn
+	g.Changed = nil
+	g.Hash = g.World.Hash()
+	g.History = []pastWorld{{turn, g.Hash, g.World}}
+	g.Period = 0
+	g.PeriodTurn = 0
+	g.Bytes = stubs.BandwidthResponse{}
+	g.Flips.start(req.CellEvents, req.Session, g.World, turn)
+	g.Trace.Start(req.Trace)
+	g.Decomposition = req.Decomposition
+	g.TurnsPerCall = req.TurnsPerCall
+	g.Verify = req.Verify
+	g.Divergences.start(req.Session, divergences)
+	if g.TurnsPerCall < 1 {
+		g.TurnsPerCall = 1
+	}
+	// The halo only reaches into the tiles next to a region, and the last tiles of some boards are narrow
+	if narrowest := g.World.Narrowest(); g.TurnsPerCall > narrowest {
+		g.Log.Info("fewer turns per call on a board with narrow tiles", "asked", g.TurnsPerCall, "turns", narrowest)
+		g.TurnsPerCall = narrowest
+	}
+	g.Evolving = true
+
+	if req.Engine == stubs.HashlifeEngine {
+		// Hashlife runs in the broker, the workers are not needed
+		g.Life, err = hashlife.New(g.World)
+		if err != nil {
+			g.Log.Error("could not start hashlife", "err", err)
+			g.Mu.Unlock()
+			return
+		}
+	} else {
+		//set up client connection
+		//global list of clients
+		g.connectWorkers()
+		// A run verified by a single worker would report no divergences without having checked anything
+		if g.Verify && len(g.Workers) < 2 {
+			err = fmt.Errorf("verifying needs two workers to compare answers, only %d connected", len(g.Workers))
+			g.Log.Error("could not start verifying", "err", err)
+			g.Evolving = false
+			g.Mu.Unlock()
+			return
 		}
-
 	}
-	fmt.Println(g.Workers)
+	g.recordRun(true)
+	g.replicate(req, true)
+	g.Mu.Unlock()
 
 	// TODO: Execute all turns of the Game of Life.
 	// Run Game of Life simulation for the specified number of turns
+	failed := 0
 	for g.Turn < p.Turns && g.Quit == false {
 		g.Mu.Lock()
-
-		var newWorld [][]byte
-		threads := len(g.Workers)
-		results := make([]chan [][]uint8, threads)
-		for id, workerClient := range g.Workers {
-			results[id] = make(chan [][]uint8)
-			go worker(id, g.World, results[id], p, workerClient, threads)
-		}
-		for i := 0; i < threads; i++ {
-			filteredMatrix := <-results[i]
-			newWorld = append(newWorld, filteredMatrix...)
+		// QuitServer may have run while we were waiting for the lock
+		if g.Quit {
+			g.Mu.Unlock()
+			break
 		}
 
-		g.World = newWorld
-		g.Turn++
+		before := g.Turn
+		if g.Life != nil {
+			start := time.Now()
+			g.Turn += g.Life.Step(p.Turns - g.Turn)
+			g.Trace.Add(tracing.Since("hashlife", tracing.ID(g.Session, g.Turn), tracing.Broker, 0, start))
+			if g.Flips.keeping() {
+				world := g.Life.World()
+				g.Flips.add(flipTurn{turn: g.Turn, world: world}, world)
+			}
+		} else {
+			var flipped []util.Cell
+			turns := g.TurnsPerCall
+			if turns > p.Turns-g.Turn {
+				turns = p.Turns - g.Turn
+			}
+			var world *tiles.World
+			world, flipped, err = g.calculateTurn(turns)
+			if err != nil {
+				// The turn is tried again from the same world, the failed workers being left out
+				failed++
+				g.Log.Warn("turn not worked out", "turn", g.Turn+turns, "attempt", failed, "err", err)
+				g.Mu.Unlock()
+				if failed == turnAttempts {
+					err = fmt.Errorf("turn %d could not be worked out after %d attempts: %v", g.Turn+turns, failed, err)
+					break
+				}
+				time.Sleep(turnRetry)
+				continue
+			}
+			failed = 0
+			g.World = world
+			g.Turn += turns
+			g.Flips.add(flipTurn{turn: g.Turn, flipped: flipped}, g.World)
+			if g.Period == 0 && g.findCycle() {
+				g.Log.Info("world repeats", "turn", g.Turn, "period", g.Period, "onCycle", req.OnCycle)
+				if req.OnCycle == stubs.SkipOnCycle {
+					g.skipCycle(p.Turns)
+					g.Flips.add(flipTurn{turn: g.Turn, world: g.World}, g.World)
+				} else if req.OnCycle == stubs.StopOnCycle {
+					g.Quit = true
+				}
+			}
+		}
+		g.recordTurn(before)
+		g.replicate(req, true)
 		g.Mu.Unlock()
+		g.Flips.wait()
 	}
 
-	res.World = g.World
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	res.Tiles = g.current().List()
 	res.Turn = g.Turn
+	res.Period = g.Period
+	res.PeriodTurn = g.PeriodTurn
+	res.Divergences = g.Divergences.list()
+	g.Life = nil
+	g.Evolving = false
+	g.recordRun(false)
+	g.replicate(req, false)
+	g.Log.Info("run finished", "turn", g.Turn, "alive", g.aliveCount(), "quit", g.Quit, "err", err)
 	return
 }
 
+// findCycle looks for the current world among the last few turns, recording how often it repeats if found.
+// Worlds are only compared cell by cell when their hashes match.
+func (g *GOLWorker) findCycle() bool {
+	hash := g.Hash
+	for _, past := range g.History {
+		if past.hash == hash && past.world.Equal(g.World) {
+			g.Period = g.Turn - past.turn
+			g.PeriodTurn = g.Turn
+			return true
+		}
+	}
+	g.History = append(g.History, pastWorld{g.Turn, hash, g.World})
+	if len(g.History) > cycleHistory {
+		g.History = g.History[1:]
+	}
+	return false
+}
+
+// skipCycle jumps to the last turn. The world then is the one from the same point in an earlier repeat.
+func (g *GOLWorker) skipCycle(turns int) {
+	target := g.Turn - g.Period + (turns-g.Turn)%g.Period
+	for _, past := range g.History {
+		if past.turn == target {
+			g.World = past.world
+			g.Hash = past.hash
+			g.Turn = turns
+			g.Changed = nil
+			return
+		}
+	}
+}
+
+// calculateTurn has the workers work out the state of their strips the given number of turns on and puts the world
+// back together. Tiles that could not have changed are carried over without being sent to a worker. The cells that
+// flipped are returned as well when the controller wants them, over several turns only those that differ at the end.
+// Nothing is put back if any strip could not be worked out, the error saying which.
+func (g *GOLWorker) calculateTurn(turns int) (*tiles.World, []util.Cell, error) {
+	start := time.Now()
+	traced := g.Trace.Enabled()
+	active := g.World.Active(g.Changed)
+	newWorld := g.World.Copy()
+	changed := make(map[tiles.Coord]bool)
+	lastChanged := make(map[tiles.Coord]bool)
+	threads := len(g.Workers)
+	base := stubs.WorldReq{
+		Width:     g.World.Width,
+		Height:    g.World.Height,
+		Unbounded: g.World.Unbounded,
+		Deltas:    true,
+		Turn:      g.Turn + turns,
+		Turns:     turns,
+		Session:   g.Session,
+	}
+	if traced {
+		base.Trace = tracing.ID(g.Session, g.Turn+turns)
+	}
+	// Workers whose connection broke are dialled again once they answer heartbeats
+	for i := range g.Workers {
+		if g.Lost[i] && g.Health.healthy(g.Addresses[i]) {
+			g.redial(i)
+		}
+	}
+	// Only healthy workers are given a region, the others are sent nothing.
+	// On an unbounded world the regions handed out follow the live area as it grows.
+	up := g.Health.up(g.Addresses)
+	shares := make([]float64, len(up))
+	for k, i := range up {
+		shares[k] = g.Shares[i]
+	}
+	regions := make([]region, threads)
+	for k, r := range partition(g.World, active, g.Decomposition, shares) {
+		regions[up[k]] = r
+	}
+	var results []strip
+	var latencies []time.Duration
+	if g.Verify {
+		results, latencies = g.verify(active, regions, base)
+	} else {
+		results, latencies = g.gather(active, regions, base)
+	}
+	// A failed strip's tiles would be left as they were a turn behind the rest, so the turn is not kept at all
+	for i, result := range results {
+		if result.err != nil {
+			g.rebalance(latencies)
+			return nil, nil, fmt.Errorf("no worker could work out the strip of %s: %v", g.Addresses[i], result.err)
+		}
+	}
+	partition := make([]stubs.WorkerStrip, threads)
+	keep := g.Flips.keeping()
+	var flipped []util.Cell
+	rawBytes, sentBytes := 0, 0
+	for i, result := range results {
+		if !g.Health.healthy(g.Addresses[i]) {
+			latencies[i] = -1
+		}
+		partition[i] = stubs.WorkerStrip{
+			Worker:   g.Addresses[i],
+			StartRow: regions[i].startRow,
+			EndRow:   regions[i].endRow,
+			StartCol: regions[i].startCol,
+			EndCol:   regions[i].endCol,
+			Active:   result.active,
+			Share:    g.Shares[i],
+			Latency:  result.latency,
+		}
+		if result.by != i {
+			partition[i].Backup = g.Addresses[result.by]
+		}
+		for j := range result.spans {
+			if result.spans[j].Node == "" {
+				result.spans[j].Node = g.Addresses[result.by]
+			}
+		}
+		g.Trace.Add(result.spans...)
+		newWorld.Add(result.tiles)
+		newWorld.Flip(result.flipped)
+
+		// A changed tile that came back neither whole nor as flipped cells has nothing left alive
+		sent := make(map[tiles.Coord]bool)
+		for _, t := range result.tiles {
+			sent[tiles.Coord{X: t.X, Y: t.Y}] = true
+		}
+		for _, cell := range result.flipped {
+			sent[tiles.CoordOf(cell)] = false
+		}
+		for _, c := range result.changed {
+			whole, ok := sent[c]
+			if !ok {
+				delete(newWorld.Tiles, c)
+			}
+			if keep && (whole || !ok) {
+				flipped = append(flipped, tiles.TileFlipped(c, g.World.Tiles[c], newWorld.Tiles[c])...)
+			}
+			changed[c] = true
+		}
+		for _, c := range result.lastChanged {
+			lastChanged[c] = true
+		}
+		if keep {
+			flipped = append(flipped, result.flipped...)
+		}
+		rawBytes += result.rawBytes
+		sentBytes += result.sentBytes
+	}
+	for c := range changed {
+		g.Hash += tiles.TileHash(c, newWorld.Tiles[c]) - tiles.TileHash(c, g.World.Tiles[c])
+	}
+	// Over several turns what can change next depends on the last turn, not on what differs since the first
+	g.Changed = changed
+	if turns > 1 {
+		g.Changed = lastChanged
+	}
+	g.countBytes(rawBytes, sentBytes, turns)
+	g.Partition = partition
+	g.Health.assign(partition)
+	g.rebalance(latencies)
+	for i, share := range g.Shares {
+		shareGauge.Set(share, g.Addresses[i])
+	}
+	if traced {
+		turn := tracing.Since("turn", base.Trace, tracing.Broker, 0, start)
+		turn.Args = map[string]int{"active": len(active), "changed": len(changed), "workers": threads}
+		g.Trace.Add(turn)
+	}
+	return newWorld, flipped, nil
+}
+
+// countBytes records how many tile bytes went to and from the workers for the turns just worked out.
+func (g *GOLWorker) countBytes(rawBytes int, sentBytes int, turns int) {
+	g.Bytes.Turn = g.Turn + turns
+	g.Bytes.RawBytes = rawBytes
+	g.Bytes.SentBytes = sentBytes
+	g.Bytes.TotalRawBytes += rawBytes
+	g.Bytes.TotalSentBytes += sentBytes
+	tileBytes.Add(float64(rawBytes), "raw")
+	tileBytes.Add(float64(sentBytes), "sent")
+}
+
+// current returns the world, taking it out of the hashlife universe first when that is what is evolving it.
+func (g *GOLWorker) current() *tiles.World {
+	if g.Life != nil {
+		g.World = g.Life.World()
+	}
+	return g.World
+}
+
+// aliveCount counts the alive cells without building the list of them.
+func (g *GOLWorker) aliveCount() int {
+	if g.Life != nil {
+		return g.Life.Population()
+	} else if g.World != nil {
+		return g.World.AliveCount()
+	}
+	return 0
+}
+
 func (g *GOLWorker) CalculateAliveCells(req stubs.Empty, res *stubs.CalculateAliveCellsResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see the world"); err != nil {
+		return
+	}
 	g.Mu.Lock()
 	defer g.Mu.Unlock()
 
-	aliveCells := []util.Cell{}
-	for i := range g.World { //height
-		for j := range g.World[i] { //width
-			if g.World[i][j] == 255 {
-				aliveCells = append(aliveCells, util.Cell{j, i})
-			}
-		}
+	if world := g.current(); world != nil {
+		res.AliveCells = world.AliveCells()
 	}
-	res.AliveCells = aliveCells
 	return
 }
 
 func (g *GOLWorker) AliveCellsCount(req stubs.Empty, res *stubs.AliveCellsCountResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see alive counts"); err != nil {
+		return
+	}
 	g.Mu.Lock()
 	defer g.Mu.Unlock()
 
-	aliveCells := []util.Cell{}
-	for i := range g.World { //height
-		for j := range g.World[i] { //width
-			if g.World[i][j] == 255 {
-				aliveCells = append(aliveCells, util.Cell{j, i})
-			}
-		}
-	}
-	res.AliveCellsCount = len(aliveCells)
+	res.AliveCellsCount = g.aliveCount()
 	res.CompletedTurns = g.Turn
+	res.Period = g.Period
+	res.PeriodTurn = g.PeriodTurn
+	res.Evolving = g.Evolving
 	return
 }
 
 func (g *GOLWorker) GetGlobal(req stubs.Empty, res *stubs.GetGlobalResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see the world"); err != nil {
+		return
+	}
 	g.Mu.Lock()
 	defer g.Mu.Unlock()
-	res.World = g.World
+	if world := g.current(); world != nil {
+		res.World = world.Dense()
+	}
 	res.Turns = g.Turn
 	return
 }
+
+// GetTiles is GetGlobal for boards too large to send densely.
+func (g *GOLWorker) GetTiles(req stubs.Empty, res *stubs.GetTilesResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see the world"); err != nil {
+		return
+	}
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	if world := g.current(); world != nil {
+		res.Tiles = world.List()
+		res.Width = world.Width
+		res.Height = world.Height
+		res.Unbounded = world.Unbounded
+		res.Bounds = world.Bounds()
+	}
+	res.Turns = g.Turn
+	return
+}
+
+// Bandwidth reports the bytes saved by packing tiles, for the last turn and the run so far.
+func (g *GOLWorker) Bandwidth(req stubs.Empty, res *stubs.BandwidthResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see bandwidth"); err != nil {
+		return
+	}
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	*res = g.Bytes
+	res.Encodings = append([]string(nil), g.Encodings...)
+	return
+}
+
+// GetFlipped hands over the turns finished since req.After, so the controller can show every one.
+// Only operators can own the log, an observer claiming to be the owner is treated as any other reader.
+func (g *GOLWorker) GetFlipped(req stubs.GetFlippedRequest, res *stubs.GetFlippedResponse) (err error) {
+	if err = req.Check(stubs.Observer, "follow runs"); err != nil {
+		return
+	}
+	res.Turns = g.Flips.fetch(req.After, req.Owner && req.Role == stubs.Operator, req.Session)
+	res.Divergences = g.Divergences.since(req.Divergences, req.Session)
+	return
+}
+
+// GetTrace hands over the spans of the last traced run, for writing out as a Chrome trace.
+func (g *GOLWorker) GetTrace(req stubs.Empty, res *stubs.GetTraceResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see traces"); err != nil {
+		return
+	}
+	res.Spans = g.Trace.Spans()
+	return
+}
+
+// GetPartition reports how the last turn was split between the workers and how long each took.
+func (g *GOLWorker) GetPartition(req stubs.Empty, res *stubs.PartitionResponse) (err error) {
+	if err = req.Check(stubs.Observer, "see the partition"); err != nil {
+		return
+	}
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	res.Turn = g.Turn
+	res.Strips = append([]stubs.WorkerStrip(nil), g.Partition...)
+	return
+}
+
+// Role tells callers whether they are operators or observers.
+func (g *GOLWorker) Role(req stubs.Empty, res *stubs.RoleResponse) (err error) {
+	if err = req.Check(stubs.Observer, "connect"); err != nil {
+		return
+	}
+	res.Role = req.Role
+	return
+}
+
 func (g *GOLWorker) QuitServer(req stubs.Empty, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "quit runs"); err != nil {
+		return
+	}
 	g.Mu.Lock()
 	defer g.Mu.Unlock()
 
+	// The world is kept so EvolveWorld can return the state the run was quit at
 	g.Quit = true
-	empty := make([][]byte, len(g.World))
-	g.World = empty
+	g.Log.Info("run quit", "turn", g.Turn)
 
 	// Close the existing client connections
 	for _, client := range g.Workers {
 		client.Close()
 	}
 	g.Workers = nil
+	g.Health.connect(nil)
 
 	return
 }
 func (g *GOLWorker) Pause(req stubs.Empty, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "pause runs"); err != nil {
+		return
+	}
 	g.Mu.Lock()
+	g.Log.Info("paused", "turn", g.Turn)
 	return
 }
 func (g *GOLWorker) Unpause(req stubs.Empty, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "unpause runs"); err != nil {
+		return
+	}
+	g.Log.Info("unpaused", "turn", g.Turn)
 	g.Mu.Unlock()
 	return
 }
 
 func (g *GOLWorker) KillServer(req stubs.Empty, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "kill the server"); err != nil {
+		return
+	}
 	// Close the existing client connections
 	emptyRes := stubs.Empty{}
 
+	logging.Info("killed, taking the workers down too")
 	for _, client := range g.Workers {
 		err = client.Call(stubs.KillHandler, req, emptyRes)
 		client.Close()
@@ -216,7 +818,25 @@ func (g *GOLWorker) KillServer(req stubs.Empty, res *stubs.Empty) (err error) {
 
 func main() {
 	pAddr := flag.String("port", "8030", "Port to listen on")
+	protocol := flag.String("transport", transport.Gob, "Protocol used to talk to the workers, gob or json")
+	compression := flag.String("compression", tiles.Flate, "Encoding for tiles sent to the workers, flate, rle or none")
+	speculate := flag.Float64("speculate", 3, "Send a strip to an idle worker as well once it takes this many times longer than the median and than its worker usually takes, 0 never does")
+	heartbeat := flag.Duration("heartbeat", time.Second, "How often to check every worker in workers.txt is answering, 0 to only find out from turns")
+	// There is no fencing: a primary that is only cut off from its standby, not stopped, carries on with its run
+	// and both brokers then send turns to the workers
+	standby := flag.String("standby", "", "Follow the broker at this address as its standby, taking its run over if it stops answering. There is no fencing, so a primary that is still running but cannot be reached carries on as well")
+	failover := flag.Duration("failover", 2*time.Second, "How long a standby waits for the broker it follows to answer before taking over")
+	heartbeatTimeout := flag.Duration("heartbeatTimeout", 3*time.Second, "How long a worker has to answer a heartbeat before it is marked unhealthy")
+	secure := transport.SecurityFlags()
+	logging.LevelFlag()
 	flag.Parse()
+	logging.SetNode("broker:" + *pAddr)
+
+	// The same certificate and token are used both to serve the controller and to call the workers
+	err := transport.Configure(*secure)
+	if err != nil {
+		logging.Fatal("could not set up security", "err", err)
+	}
 
 	go func() {
 		for {
@@ -226,13 +846,27 @@ func main() {
 		}
 	}()
 
-	rpc.Register(&GOLWorker{})
+	g := &GOLWorker{Transport: *protocol, Compression: *compression, Speculate: *speculate, Log: logging.With()}
+	rpc.Register(g)
+	g.serveAPI()
+	g.serveViewer()
+	if *heartbeat > 0 {
+		go g.heartbeat(*heartbeat, *heartbeatTimeout)
+	}
+	if *standby != "" {
+		g.Primary = *standby
+		g.Promoted = make(chan bool)
+		go g.follow(*failover)
+	}
 	listener, err := net.Listen("tcp", ":"+*pAddr)
 	if err != nil {
-		fmt.Printf("Error starting listener: %s\n", err)
-		os.Exit(1)
+		logging.Fatal("could not listen", "port", *pAddr, "err", err)
 	}
 	defer listener.Close()
+	logging.Info("listening", "port", *pAddr, "transport", *protocol, "compression", *compression)
 
-	rpc.Accept(listener)
+	err = transport.Serve(listener, rpc.DefaultServer)
+	if err != nil {
+		logging.Error("stopped accepting connections", "err", err)
+	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
+// fakeWorker stands in for a worker. It answers every strip with nothing changed after delay, or fails when
+// fail is set.
+type fakeWorker struct {
+	delay time.Duration
+	fail  bool
+}
+
+func (w *fakeWorker) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	time.Sleep(w.delay)
+	if w.fail {
+		return errors.New("worker failed")
+	}
+	return nil
+}
+
+func (w *fakeWorker) Close() error {
+	return nil
+}
+
+// fakeBroker has the given workers work out a world three tiles high with a block in every tile, which no turn
+// changes, so nothing changed is the right answer for every strip.
+func fakeBroker(workers ...*fakeWorker) *GOLWorker {
+	g := &GOLWorker{World: tiles.New(64, 3*tiles.Size), Log: logging.With("session", "test")}
+	for y := 0; y < 3; y++ {
+		for _, cell := range [][2]int{{10, 10}, {11, 10}, {10, 11}, {11, 11}} {
+			g.World.Set(cell[0], y*tiles.Size+cell[1], 255)
+		}
+	}
+	g.Hash = g.World.Hash()
+	for i, w := range workers {
+		g.Workers = append(g.Workers, w)
+		g.Addresses = append(g.Addresses, fmt.Sprintf("worker%d", i))
+	}
+	g.Encodings = make([]string, len(workers))
+	g.Lost = make([]bool, len(workers))
+	g.resetShares()
+	return g
+}
+
+// TestCalculateTurnFailed checks a turn no worker could work out a strip of is not kept.
+func TestCalculateTurnFailed(t *testing.T) {
+	g := fakeBroker(&fakeWorker{fail: true}, &fakeWorker{fail: true}, &fakeWorker{fail: true})
+	world, hash := g.World, g.Hash
+	if _, _, err := g.calculateTurn(1); err == nil {
+		t.Fatal("expected the turn to fail with every worker failing")
+	}
+	if g.World != world || g.Hash != hash || g.Changed != nil {
+		t.Errorf("expected the world left as it was after a failed turn")
+	}
+
+	g = fakeBroker(&fakeWorker{}, &fakeWorker{}, &fakeWorker{})
+	next, _, err := g.calculateTurn(1)
+	if err != nil {
+		t.Fatalf("expected the turn to be worked out, got %v", err)
+	}
+	if !next.Equal(world) {
+		t.Errorf("expected the blocks to stay put")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxFlipTurns is how many turns are kept for the controller before the run waits for it to catch up.
+const maxFlipTurns = 64
+
+// flipTimeout is how long the run waits for the controller before it stops keeping flipped cells.
+const flipTimeout = 5 * time.Second
+
+// flipTurn is one turn of the log. world is set instead of flipped when readers have to resync.
+type flipTurn struct {
+	turn    int
+	flipped []util.Cell
+	world   *tiles.World
+}
+
+// flipLog keeps the cells flipped each turn until the controller that started the run fetches them.
+// Observers read the same turns without dropping them.
+type flipLog struct {
+	mu      sync.Mutex
+	enabled bool
+	session string
+	turns   []flipTurn
+	fetched time.Time
+	// latest is the world after the last turn added, for readers whose turns have already been dropped
+	latest     *tiles.World
+	latestTurn int
+}
+
+func (l *flipLog) start(enabled bool, session string, world *tiles.World, turn int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = enabled
+	l.session = session
+	l.turns = nil
+	l.fetched = time.Now()
+	l.latest = world
+	l.latestTurn = turn
+}
+
+// keeping reports whether anyone is fetching flipped cells, so they are only worked out when needed.
+func (l *flipLog) keeping() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// add records a turn along with the world after it. Worlds are never changed once made so keeping one is cheap.
+func (l *flipLog) add(turn flipTurn, world *tiles.World) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enabled {
+		l.turns = append(l.turns, turn)
+		l.latest = world
+		l.latestTurn = turn.turn
+	}
+}
+
+// wait holds the run back while the controller is too far behind, giving up on it if it stops fetching.
+func (l *flipLog) wait() {
+	for {
+		l.mu.Lock()
+		full := l.enabled && len(l.turns) >= maxFlipTurns
+		if full && time.Since(l.fetched) > flipTimeout {
+			l.enabled = false
+			l.turns = nil
+			full = false
+		}
+		l.mu.Unlock()
+		if !full {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fetch returns the turns after the given one. The owner's fetch empties the log. Turns of a run other than
+// session are never handed over, so a controller that starts fetching before its run has started is not sent
+// the last run's world.
+func (l *flipLog) fetch(after int, owner bool, session string) []stubs.TurnFlips {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled || (session != "" && session != l.session) {
+		return nil
+	}
+	var turns []stubs.TurnFlips
+	if after < l.latestTurn && (len(l.turns) == 0 || l.turns[0].turn > after+1) {
+		// The turns this reader has not seen yet are gone, or it has only just started following
+		turns = append(turns, stubs.TurnFlips{Turn: l.latestTurn, Resync: true, Tiles: l.latest.List()})
+	} else {
+		for _, t := range l.turns {
+			if t.turn <= after {
+				continue
+			}
+			if t.world != nil {
+				turns = append(turns, stubs.TurnFlips{Turn: t.turn, Resync: true, Tiles: t.world.List()})
+			} else {
+				turns = append(turns, stubs.TurnFlips{Turn: t.turn, Flipped: t.flipped})
+			}
+		}
+	}
+	if owner {
+		l.fetched = time.Now()
+		l.turns = nil
+	}
+	return turns
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// health keeps track of which workers are answering. Heartbeats go to every worker in workers.txt over
+// connections of their own, so a worker busy with a turn is still heard from. Any call that fails marks the
+// worker unhealthy until it answers again, and unhealthy workers are not sent turns.
+type health struct {
+	mu        sync.Mutex
+	addresses []string
+	workers   map[string]*stubs.WorkerStatus
+	// clients are the heartbeat's connections, pinging the workers that have not answered the last heartbeat yet
+	clients map[string]transport.Client
+	pinging map[string]bool
+}
+
+// status returns what is known about the worker at address, adding it the first time. Called with h.mu held.
+func (h *health) status(address string) *stubs.WorkerStatus {
+	if h.workers == nil {
+		h.workers = make(map[string]*stubs.WorkerStatus)
+	}
+	s, ok := h.workers[address]
+	if !ok {
+		s = &stubs.WorkerStatus{Address: address, Status: stubs.WorkerUnknown}
+		h.workers[address] = s
+		h.addresses = append(h.addresses, address)
+	}
+	return s
+}
+
+// record marks a worker healthy or unhealthy after a call to it, logging when that changes.
+func (h *health) record(address string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recordWorker(address, err)
+	s := h.status(address)
+	if err != nil {
+		if s.Status != stubs.WorkerUnhealthy {
+			logging.Warn("worker unhealthy", "worker", address, "err", err)
+		}
+		s.Status = stubs.WorkerUnhealthy
+		s.Error = err.Error()
+		return
+	}
+	if s.Status == stubs.WorkerUnhealthy {
+		logging.Info("worker healthy again", "worker", address)
+	}
+	s.Status = stubs.WorkerHealthy
+	s.LastSeen = time.Now()
+}
+
+func (h *health) healthy(address string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status(address).Status != stubs.WorkerUnhealthy
+}
+
+// up picks which of the workers to send the next turn to. When none are healthy all of them are tried rather
+// than stalling the run.
+func (h *health) up(addresses []string) []int {
+	var up []int
+	for i, address := range addresses {
+		if h.healthy(address) {
+			up = append(up, i)
+		}
+	}
+	if len(up) == 0 {
+		for i := range addresses {
+			up = append(up, i)
+		}
+	}
+	return up
+}
+
+// connect records which workers the broker has a connection to for turns.
+func (h *health) connect(addresses []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.workers {
+		s.Connected = false
+	}
+	for _, address := range addresses {
+		h.status(address).Connected = true
+	}
+}
+
+// assign records what each worker was given on the last turn.
+func (h *health) assign(partition []stubs.WorkerStrip) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range partition {
+		h.status(s.Worker).Strip = s
+	}
+}
+
+// list returns a copy of everything known about the workers.
+func (h *health) list() []stubs.WorkerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	workers := make([]stubs.WorkerStatus, len(h.addresses))
+	for i, address := range h.addresses {
+		workers[i] = *h.workers[address]
+	}
+	return workers
+}
+
+// ping sends a worker a heartbeat, dialling it first if need be, and marks it unhealthy if that fails or is not
+// answered within timeout. A worker still working on the last heartbeat is not sent another.
+func (h *health) ping(protocol string, address string, timeout time.Duration) {
+	h.mu.Lock()
+	if h.pinging == nil {
+		h.pinging = make(map[string]bool)
+		h.clients = make(map[string]transport.Client)
+	}
+	if h.pinging[address] {
+		h.mu.Unlock()
+		return
+	}
+	h.pinging[address] = true
+	client := h.clients[address]
+	h.mu.Unlock()
+
+	answered := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		var err error
+		if client == nil {
+			client, err = transport.Dial(protocol, address)
+		}
+		if err == nil {
+			err = client.Call(stubs.HeartbeatHandler, stubs.Empty{}, &stubs.Empty{})
+		}
+		latency := time.Since(start)
+
+		h.mu.Lock()
+		h.pinging[address] = false
+		if err == nil {
+			h.clients[address] = client
+			h.status(address).Latency = latency
+		} else {
+			// The connection may be broken, the next heartbeat dials again
+			if client != nil {
+				client.Close()
+			}
+			delete(h.clients, address)
+		}
+		h.mu.Unlock()
+		answered <- err
+	}()
+
+	select {
+	case err := <-answered:
+		h.record(address, err)
+	case <-time.After(timeout):
+		h.record(address, fmt.Errorf("no answer to heartbeat within %v", timeout))
+	}
+}
+
+// heartbeat checks on every worker in workers.txt every interval, for as long as the broker runs.
+func (g *GOLWorker) heartbeat(interval time.Duration, timeout time.Duration) {
+	for range time.Tick(interval) {
+		for _, address := range ReadFileLines("workers.txt") {
+			go g.Health.ping(g.Transport, address, timeout)
+		}
+	}
+}
+
+// redial replaces the connection to a worker after a call over it failed. If the worker cannot be dialled it is
+// marked unhealthy and tried again once it answers heartbeats.
+func (g *GOLWorker) redial(i int) {
+	client, err := transport.Dial(g.Transport, g.Addresses[i])
+	if err != nil {
+		g.Health.record(g.Addresses[i], err)
+		return
+	}
+	g.Log.Info("reconnected to worker", "worker", g.Addresses[i])
+	g.Workers[i] = client
+	g.Encodings[i] = g.negotiate(client)
+	g.Lost[i] = false
+}
+
+// ListWorkers reports which workers are answering and what they were given on the last turn. It does not wait
+// for a turn, or a pause, to finish.
+func (g *GOLWorker) ListWorkers(req stubs.Empty, res *stubs.ListWorkersResponse) (err error) {
+	if err = req.Check(stubs.Observer, "list workers"); err != nil {
+		return
+	}
+	res.Workers = g.Health.list()
+	return
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/metrics"
+)
+
+var turnGauge = metrics.NewGauge("gol_turn", "Turns completed in the current or last run.")
+var turnsTotal = metrics.NewCounter("gol_turns_total", "Turns completed since the broker started.")
+var turnsPerSecond = metrics.NewGauge("gol_turns_per_second", "Turns completed per second, worked out about once a second.")
+var aliveGauge = metrics.NewGauge("gol_alive_cells", "Alive cells, counted about once a second while a run goes on.")
+var evolvingGauge = metrics.NewGauge("gol_evolving", "1 while a run is going on.")
+var workerUp = metrics.NewGauge("gol_worker_up", "1 if the last call to the worker worked, 0 if it failed.", "worker")
+var stripsResent = metrics.NewCounter("gol_strips_resent_total", "Strips sent to a second worker because the first was slow or failed.", "reason")
+var divergences = metrics.NewCounter("gol_divergences_total", "Strips two workers worked out differently when verifying results.")
+var unverifiedStrips = metrics.NewCounter("gol_unverified_strips_total", "Strips only one worker worked out when verifying results, as no other could check them.")
+var tileBytes = metrics.NewCounter("gol_tile_bytes_total", "Tile bytes sent to and from the workers, raw is what they would have taken unpacked.", "size")
+
+// turnRate is where turns per second was last worked out from.
+type turnRate struct {
+	since time.Time
+	turn  int
+}
+
+// recordTurn updates the metrics after the turns from before up to g.Turn. Counting alive cells goes over
+// the whole world so it is only done when the rate is. Called with g.Mu held.
+func (g *GOLWorker) recordTurn(before int) {
+	turnGauge.Set(float64(g.Turn))
+	turnsTotal.Add(float64(g.Turn - before))
+	elapsed := time.Since(g.Rate.since)
+	if elapsed < time.Second {
+		return
+	}
+	turnsPerSecond.Set(float64(g.Turn-g.Rate.turn) / elapsed.Seconds())
+	aliveGauge.Set(float64(g.aliveCount()))
+	g.Rate = turnRate{time.Now(), g.Turn}
+}
+
+// recordRun sets the metrics for a run starting or finishing.
+func (g *GOLWorker) recordRun(evolving bool) {
+	g.Rate = turnRate{time.Now(), g.Turn}
+	turnGauge.Set(float64(g.Turn))
+	turnsPerSecond.Set(0)
+	aliveGauge.Set(float64(g.aliveCount()))
+	if evolving {
+		evolvingGauge.Set(1)
+	} else {
+		evolvingGauge.Set(0)
+	}
+}
+
+// recordWorker marks a worker up or down after a call to it.
+func recordWorker(address string, err error) {
+	if err != nil {
+		workerUp.Set(0, address)
+	} else {
+		workerUp.Set(1, address)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
+// minStraggle is how long a strip can always take before it is sent to another worker, so turns that only take
+// a millisecond or two are not worked out twice because of a hiccup.
+const minStraggle = 10 * time.Millisecond
+
+// estimate is how long a worker's calls usually take and how far they stray from that, kept the way TCP keeps
+// round trip times so the odd slow call widens the margin instead of being taken for a stuck worker.
+type estimate struct {
+	mean      time.Duration
+	deviation time.Duration
+}
+
+// add folds a call's latency into the estimate.
+func (e *estimate) add(latency time.Duration) {
+	if e.mean == 0 {
+		e.mean, e.deviation = latency, latency/2
+		return
+	}
+	diff := latency - e.mean
+	if diff < 0 {
+		diff = -diff
+	}
+	e.deviation += (diff - e.deviation) / 4
+	e.mean += (latency - e.mean) / 8
+}
+
+// usual is about the longest a call of the worker's takes when nothing is wrong, 0 before it has been measured.
+func (e estimate) usual() time.Duration {
+	return e.mean + 4*e.deviation
+}
+
+// gather sends every worker its strip of the turn and waits for all of them. A strip that takes more than
+// Speculate times as long as both the median strip and the longest its worker usually takes is sent to a worker
+// that has finished its own as well, and whichever answer comes first is used. Workers that have not been
+// measured yet are waited for. A strip that fails is sent again to the next answering worker to be free, for as long
+// as there are any, and is only left failed once every worker has failed. It returns the strip meant for each
+// worker and how long each worker took, -1 for a failed call. A worker whose own strip was not waited for is given
+// as long as the whole turn took.
+func (g *GOLWorker) gather(active map[tiles.Coord]bool, regions []region, base stubs.WorldReq) ([]strip, []time.Duration) {
+	threads := len(g.Workers)
+	start := time.Now()
+	// Answers that are not needed any more are left in the buffer, a strip is only sent again to a worker that
+	// failed or is done with what it had, so no more than one per worker can be left over
+	done := make(chan strip, 2*threads)
+	out := make([]int, threads)
+	busy := make([]bool, threads)
+	send := func(part int, by int) {
+		out[part]++
+		busy[by] = true
+		go worker(by, part, g.World, active, done, g.Workers[by], g.Encodings[by], regions[part], base)
+	}
+	for i := range g.Workers {
+		send(i, i)
+	}
+
+	results := make([]strip, threads)
+	got := make([]bool, threads)
+	remaining := threads
+	resent := make([]bool, threads)
+	answered := make([]bool, threads)
+	// waiting are failed strips for the next worker to be free, failures their last answer
+	var waiting []int
+	failures := make([]strip, threads)
+	latencies := make([]time.Duration, threads)
+	var measured []time.Duration
+
+	// idle is a worker that is answering and has nothing to do, -1 when there are none
+	idle := func() int {
+		for i := range g.Workers {
+			if !busy[i] && !g.Lost[i] && g.Health.healthy(g.Addresses[i]) {
+				return i
+			}
+		}
+		return -1
+	}
+	resend := func(part int, reason string) bool {
+		by := idle()
+		if resent[part] || by < 0 {
+			return false
+		}
+		resent[part] = true
+		stripsResent.Add(1, reason)
+		g.Log.Info("strip sent to another worker", "worker", g.Addresses[part], "to", g.Addresses[by], "reason", reason,
+			"turn", base.Turn, "after", time.Since(start))
+		send(part, by)
+		return true
+	}
+
+	// answering reports whether any worker can still be sent a strip, now or once it is free
+	answering := func() bool {
+		for i := range g.Workers {
+			if !g.Lost[i] && g.Health.healthy(g.Addresses[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	// retry hands out failed strips to the workers that are free, giving up on them once no worker is answering
+	retry := func() {
+		for len(waiting) > 0 {
+			by := idle()
+			if by < 0 {
+				break
+			}
+			part := waiting[0]
+			waiting = waiting[1:]
+			resent[part] = true
+			stripsResent.Add(1, "failed")
+			g.Log.Info("strip sent to another worker", "worker", g.Addresses[part], "to", g.Addresses[by], "reason", "failed",
+				"turn", base.Turn, "after", time.Since(start))
+			send(part, by)
+		}
+		if len(waiting) > 0 && !answering() {
+			for _, part := range waiting {
+				results[part] = failures[part]
+				got[part] = true
+				remaining--
+			}
+			waiting = nil
+		}
+	}
+
+	// straggler is the measured strip still out and not sent again that will straggle first and how long it can
+	// take, -1 when there are none
+	straggler := func(median time.Duration) (int, time.Duration) {
+		first, limit := -1, time.Duration(0)
+		for part := range results {
+			usual := g.Took[part].usual()
+			if got[part] || resent[part] || usual == 0 {
+				continue
+			}
+			if usual < median {
+				usual = median
+			}
+			l := time.Duration(g.Speculate * float64(usual))
+			if l < minStraggle {
+				l = minStraggle
+			}
+			if first < 0 || l < limit {
+				first, limit = part, l
+			}
+		}
+		return first, limit
+	}
+
+	for remaining > 0 {
+		// Once half the strips are in, any still out after Speculate times their median and what their worker
+		// usually takes are straggling
+		var straggling <-chan time.Time
+		slow := -1
+		if g.Speculate > 0 && len(measured) > 0 && remaining*2 <= threads && idle() >= 0 {
+			sort.Slice(measured, func(i, j int) bool { return measured[i] < measured[j] })
+			var limit time.Duration
+			if slow, limit = straggler(measured[len(measured)/2]); slow >= 0 {
+				straggling = time.After(time.Until(start.Add(limit)))
+			}
+		}
+
+		select {
+		case result := <-done:
+			out[result.part]--
+			busy[result.by] = false
+			if result.called {
+				g.Health.record(g.Addresses[result.by], result.err)
+				if result.err == nil && result.by == result.part {
+					g.Took[result.by].add(result.latency)
+				}
+			}
+			if result.err != nil {
+				g.Log.Error("worker call failed", "worker", g.Addresses[result.by], "turn", base.Turn, "err", result.err)
+				g.Workers[result.by].Close()
+				g.Lost[result.by] = true
+			}
+			if result.by == result.part {
+				answered[result.by] = true
+				latencies[result.by] = result.latency
+				if result.err != nil {
+					latencies[result.by] = -1
+				}
+			}
+			if !got[result.part] && result.err == nil {
+				results[result.part] = result
+				got[result.part] = true
+				remaining--
+				if result.called {
+					measured = append(measured, result.latency)
+				}
+			} else if !got[result.part] && out[result.part] == 0 {
+				// A failed strip waits for a free worker, unless another answer to it is still to come
+				failures[result.part] = result
+				waiting = append(waiting, result.part)
+			}
+			retry()
+		case <-straggling:
+			resend(slow, "slow")
+		}
+	}
+
+	for i := range latencies {
+		if !answered[i] {
+			latencies[i] = time.Since(start)
+		}
+	}
+	return results, latencies
+}
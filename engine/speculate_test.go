@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGatherFailed checks a failed strip is worked out by another worker even when every other worker is busy
+// at the time or fails as well, and that the turn only fails once no worker is left.
+func TestGatherFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers []*fakeWorker
+		fails   bool
+	}{
+		{"others busy", []*fakeWorker{{fail: true}, {delay: 20 * time.Millisecond}, {delay: 20 * time.Millisecond}}, false},
+		{"others failing", []*fakeWorker{{fail: true}, {delay: 10 * time.Millisecond, fail: true}, {delay: 5 * time.Millisecond}}, false},
+		{"all failing", []*fakeWorker{{fail: true}, {fail: true}, {delay: 5 * time.Millisecond, fail: true}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := fakeBroker(test.workers...)
+			_, _, err := g.calculateTurn(1)
+			if test.fails {
+				if err == nil {
+					t.Fatal("expected the turn to fail with every worker failing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the failed strips to be worked out by another worker, got %v", err)
+			}
+			for i, w := range test.workers {
+				if w.fail && g.Partition[i].Backup == "" {
+					t.Errorf("expected the strip of %s to have been worked out by another worker, got %+v", g.Addresses[i], g.Partition[i])
+				}
+			}
+		})
+	}
+}
+
+// TestGatherStraggler has one of three healthy workers always take a lot longer than the others and checks its
+// strip is never sent to another worker, then has a worker that is usually quick hang and checks its strip is.
+func TestGatherStraggler(t *testing.T) {
+	workers := []*fakeWorker{{delay: 2 * time.Millisecond}, {delay: 2 * time.Millisecond}, {delay: 30 * time.Millisecond}}
+	g := fakeBroker(workers...)
+	g.Speculate = 3
+	const turns = 20
+	for turn := 1; turn <= turns; turn++ {
+		// The blocks never change, so every tile is marked changed to keep every worker busy
+		g.Changed = nil
+		if _, _, err := g.calculateTurn(turn); err != nil {
+			t.Fatalf("expected the turn to be worked out, got %v", err)
+		}
+		for _, strip := range g.Partition {
+			if strip.Backup != "" {
+				t.Fatalf("expected no strip to be sent to another worker, got %+v on turn %d", strip, turn)
+			}
+		}
+	}
+
+	workers[1].delay = 500 * time.Millisecond
+	g.Changed = nil
+	if _, _, err := g.calculateTurn(turns + 1); err != nil {
+		t.Fatalf("expected the turn to be worked out, got %v", err)
+	}
+	if g.Partition[1].Backup == "" {
+		t.Errorf("expected the strip of a hung worker to be sent to another, got %+v", g.Partition[1])
+	}
+}
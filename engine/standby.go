@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/hashlife"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// replicaInterval is how often a standby asks the primary for the state of its run.
+const replicaInterval = 200 * time.Millisecond
+
+// replica is the state of the run after the last turn, kept apart from g.Mu so a standby can still read it while
+// the run is paused. Under hashlife life is kept instead of the world until the world is asked for.
+type replica struct {
+	mu    sync.Mutex
+	state stubs.ReplicateResponse
+	world *tiles.World
+	life  *hashlife.Snapshot
+}
+
+// current returns the world after the last turn, building it out of the hashlife snapshot the first time it is
+// asked for. Called with r.mu held.
+func (r *replica) current() *tiles.World {
+	if r.life != nil {
+		r.world = r.life.World()
+		r.life = nil
+	}
+	return r.world
+}
+
+// replicate records the state of the run for standbys. Called with g.Mu held.
+func (g *GOLWorker) replicate(req stubs.EvolveWorldRequest, evolving bool) {
+	req.Tiles = nil
+	g.Replica.mu.Lock()
+	defer g.Replica.mu.Unlock()
+	g.Replica.state = stubs.ReplicateResponse{
+		Run:         req,
+		Evolving:    evolving,
+		Turn:        g.Turn,
+		Divergences: g.Divergences.list(),
+	}
+	g.Replica.world, g.Replica.life = g.World, nil
+	if g.Life != nil {
+		// Only a snapshot is taken every turn, the world is built from it if anyone asks for it
+		life := g.Life.Snapshot()
+		g.Replica.world, g.Replica.life = nil, &life
+	}
+}
+
+// Replicate hands a standby the state of the run. The world is only sent when the standby does not have it yet.
+func (g *GOLWorker) Replicate(req stubs.ReplicateRequest, res *stubs.ReplicateResponse) (err error) {
+	if err = req.Check(stubs.Operator, "replicate runs"); err != nil {
+		return
+	}
+	g.Replica.mu.Lock()
+	defer g.Replica.mu.Unlock()
+	*res = g.Replica.state
+	if req.After != res.Turn || req.Session != res.Run.Session {
+		if world := g.Replica.current(); world != nil {
+			res.HasWorld = true
+			res.Tiles = world.List()
+		}
+	}
+	return
+}
+
+// standingBy reports whether this broker is a standby that has not taken over yet.
+func (g *GOLWorker) standingBy() bool {
+	select {
+	case <-g.Promoted:
+		return false
+	default:
+		return g.Primary != ""
+	}
+}
+
+// poll keeps asking the primary for the state of its run, sending on every answer until stop is closed.
+func (g *GOLWorker) poll(replies chan<- stubs.ReplicateResponse, stop <-chan bool) {
+	var client transport.Client
+	req := stubs.ReplicateRequest{After: -1}
+	for range time.Tick(replicaInterval) {
+		var err error
+		if client == nil {
+			client, err = transport.Dial(g.Transport, g.Primary)
+			if err != nil {
+				client = nil
+				continue
+			}
+		}
+		res := stubs.ReplicateResponse{}
+		err = client.Call(stubs.ReplicateHandler, req, &res)
+		if err != nil {
+			client.Close()
+			client = nil
+			continue
+		}
+		req.After, req.Session = res.Turn, res.Run.Session
+		select {
+		case replies <- res:
+		case <-stop:
+			client.Close()
+			return
+		}
+	}
+}
+
+// follow keeps a copy of the primary's run, which observers of the standby can see. Once the primary has not
+// answered for longer than failover the standby takes over, carrying the run on from the last turn it heard of
+// with the workers in its own workers.txt.
+func (g *GOLWorker) follow(failover time.Duration) {
+	replies := make(chan stubs.ReplicateResponse)
+	stop := make(chan bool)
+	go g.poll(replies, stop)
+
+	var state stubs.ReplicateResponse
+	var world *tiles.World
+	heard := false
+	for following := true; following; {
+		select {
+		case res := <-replies:
+			if !heard {
+				g.Log.Info("following the primary", "primary", g.Primary)
+				heard = true
+			}
+			// A world that has died out comes with no tiles, so it is replaced whenever one was sent
+			if res.HasWorld {
+				world = tiles.FromList(res.Run.ImageWidth, res.Run.ImageHeight, res.Tiles)
+				world.Unbounded = res.Run.Unbounded
+			}
+			state = res
+			g.Mu.Lock()
+			g.World = world
+			g.Turn = state.Turn
+			g.Session = state.Run.Session
+			g.Mu.Unlock()
+		case <-time.After(failover):
+			following = false
+		}
+	}
+	close(stop)
+
+	g.Log.Warn("primary stopped answering, taking over", "primary", g.Primary, "turn", state.Turn, "evolving", state.Evolving)
+	g.Running.Lock()
+	close(g.Promoted)
+	defer g.Running.Unlock()
+	if state.Evolving && world != nil {
+		g.run(state.Run, world, state.Turn, state.Divergences, &stubs.EvolveResponse{})
+	}
+}
+
+// Resume waits for the run a standby took over to finish and answers as EvolveWorld would have. A standby that has
+// not taken over yet is waited for first, so a controller that lost the primary can call it straight away.
+func (g *GOLWorker) Resume(req stubs.Empty, res *stubs.EvolveResponse) (err error) {
+	if err = req.Check(stubs.Operator, "resume runs"); err != nil {
+		return
+	}
+	if g.Promoted != nil {
+		<-g.Promoted
+	}
+	g.Running.Lock()
+	defer g.Running.Unlock()
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	if world := g.current(); world != nil {
+		res.Tiles = world.List()
+	}
+	res.Turn = g.Turn
+	res.Period = g.Period
+	res.PeriodTurn = g.PeriodTurn
+	res.Divergences = g.Divergences.list()
+	return
+}
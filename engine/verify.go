@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
+// maxDivergences is how many divergences a run keeps, any after that are only logged.
+const maxDivergences = 100
+
+// divergenceLog is where the workers disagreed this run. It has a lock of its own so the controller can fetch
+// divergences as they are found, without waiting for the turn or a pause to finish.
+type divergenceLog struct {
+	mu      sync.Mutex
+	session string
+	found   []stubs.Divergence
+	dropped int
+	// unverified is how many turns had a strip only one worker worked out
+	unverified int
+}
+
+// start clears the log for a run, carrying on with any divergences found before a standby took it over.
+func (l *divergenceLog) start(session string, found []stubs.Divergence) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.session = session
+	l.found = append([]stubs.Divergence(nil), found...)
+	l.dropped = 0
+	l.unverified = 0
+}
+
+// add keeps a divergence while there is room, returning how many have been dropped for lack of it.
+func (l *divergenceLog) add(d stubs.Divergence) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.found) < maxDivergences {
+		l.found = append(l.found, d)
+	} else {
+		l.dropped++
+	}
+	return l.dropped
+}
+
+// skip records a turn that was not fully verified, returning how many have not been this run.
+func (l *divergenceLog) skip() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unverified++
+	return l.unverified
+}
+
+// list returns a copy of the divergences kept.
+func (l *divergenceLog) list() []stubs.Divergence {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]stubs.Divergence(nil), l.found...)
+}
+
+// since returns the divergences after the first n, nothing when session is another run's.
+func (l *divergenceLog) since(n int, session string) []stubs.Divergence {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if (session != "" && session != l.session) || n >= len(l.found) {
+		return nil
+	}
+	return append([]stubs.Divergence(nil), l.found[n:]...)
+}
+
+// verify sends every strip to the worker it is meant for and to the next worker along that is answering, then
+// compares the two answers, recording a divergence wherever they disagree. The first worker's answer is used
+// unless its call failed. Strips no second worker could check are warned about every turn, so a run is never
+// taken to be verified when it was not. It returns the same as gather.
+func (g *GOLWorker) verify(active map[tiles.Coord]bool, regions []region, base stubs.WorldReq) ([]strip, []time.Duration) {
+	threads := len(g.Workers)
+	up := g.Health.up(g.Addresses)
+	done := make(chan strip, 2*threads)
+	sent := 0
+	for i := range g.Workers {
+		go worker(i, i, g.World, active, done, g.Workers[i], g.Encodings[i], regions[i], base)
+		sent++
+	}
+	if len(up) > 1 {
+		for k, i := range up {
+			by := up[(k+1)%len(up)]
+			go worker(by, i, g.World, active, done, g.Workers[by], g.Encodings[by], regions[i], base)
+			sent++
+		}
+	}
+
+	results := make([]strip, threads)
+	checks := make([]strip, threads)
+	checked := make([]bool, threads)
+	latencies := make([]time.Duration, threads)
+	for ; sent > 0; sent-- {
+		result := <-done
+		if result.called {
+			g.Health.record(g.Addresses[result.by], result.err)
+		}
+		if result.err != nil {
+			g.Log.Error("worker call failed", "worker", g.Addresses[result.by], "turn", base.Turn, "err", result.err)
+			g.Workers[result.by].Close()
+			g.Lost[result.by] = true
+		}
+		if result.by == result.part {
+			results[result.part] = result
+			latencies[result.by] = result.latency
+			if result.err != nil {
+				latencies[result.by] = -1
+			}
+		} else {
+			checks[result.part] = result
+			checked[result.part] = true
+		}
+	}
+
+	var unchecked []string
+	for i := range results {
+		if !checked[i] || checks[i].err != nil || results[i].err != nil {
+			unchecked = append(unchecked, g.Addresses[i])
+		}
+		if !checked[i] || checks[i].err != nil {
+			continue
+		}
+		if results[i].err != nil {
+			results[i] = checks[i]
+			continue
+		}
+		if differ := disagree(g.World, results[i], checks[i]); len(differ) > 0 {
+			g.diverged(base.Turn, differ, g.Addresses[i], g.Addresses[checks[i].by])
+		}
+	}
+	if len(unchecked) > 0 {
+		unverifiedStrips.Add(float64(len(unchecked)))
+		g.Log.Warn("turn not verified, no other worker checked some strips", "turn", base.Turn, "strips", unchecked,
+			"unverifiedTurns", g.Divergences.skip())
+	}
+	return results, latencies
+}
+
+// diverged records two workers disagreeing on the given tiles.
+func (g *GOLWorker) diverged(turn int, differ []tiles.Coord, workers ...string) {
+	first, last := differ[0], differ[0]
+	for _, c := range differ {
+		if c.X < first.X {
+			first.X = c.X
+		}
+		if c.Y < first.Y {
+			first.Y = c.Y
+		}
+		if c.X > last.X {
+			last.X = c.X
+		}
+		if c.Y > last.Y {
+			last.Y = c.Y
+		}
+	}
+	d := stubs.Divergence{
+		Turn:     turn,
+		StartRow: first.Y * tiles.Size,
+		EndRow:   (last.Y + 1) * tiles.Size,
+		StartCol: first.X * tiles.Size,
+		EndCol:   (last.X + 1) * tiles.Size,
+		Workers:  workers,
+		Tiles:    len(differ),
+	}
+	if !g.World.Unbounded && d.EndRow > g.World.Height {
+		d.EndRow = g.World.Height
+	}
+	if !g.World.Unbounded && d.EndCol > g.World.Width {
+		d.EndCol = g.World.Width
+	}
+	divergences.Add(1)
+	g.Log.Error("workers disagree", "turn", turn, "workers", workers, "startRow", d.StartRow, "endRow", d.EndRow,
+		"startCol", d.StartCol, "endCol", d.EndCol, "tiles", d.Tiles)
+	if g.Divergences.add(d) == 1 {
+		g.Log.Warn("too many divergences to keep, the rest are only logged", "kept", maxDivergences)
+	}
+}
+
+// disagree returns the tiles two answers for the same strip leave different.
+func disagree(world *tiles.World, a strip, b strip) []tiles.Coord {
+	var coords []tiles.Coord
+	seen := make(map[tiles.Coord]bool)
+	for _, c := range append(append([]tiles.Coord(nil), a.changed...), b.changed...) {
+		if !seen[c] {
+			seen[c] = true
+			coords = append(coords, c)
+		}
+	}
+	nextA, nextB := outcome(world, a, coords), outcome(world, b, coords)
+	var differ []tiles.Coord
+	for _, c := range coords {
+		if !sameTile(nextA.Tiles[c], nextB.Tiles[c]) {
+			differ = append(differ, c)
+		}
+	}
+	return differ
+}
+
+// outcome is what the given tiles are after an answer is applied, the same way calculateTurn applies it.
+func outcome(world *tiles.World, s strip, coords []tiles.Coord) *tiles.World {
+	next := tiles.New(world.Width, world.Height)
+	for _, c := range coords {
+		if t := world.Tiles[c]; t != nil {
+			next.Tiles[c] = t
+		}
+	}
+	next.Add(s.tiles)
+	next.Flip(s.flipped)
+	// A changed tile that came back neither whole nor as flipped cells has nothing left alive
+	sent := make(map[tiles.Coord]bool)
+	for _, t := range s.tiles {
+		sent[tiles.Coord{X: t.X, Y: t.Y}] = true
+	}
+	for _, cell := range s.flipped {
+		sent[tiles.CoordOf(cell)] = true
+	}
+	for _, c := range s.changed {
+		if !sent[c] {
+			delete(next.Tiles, c)
+		}
+	}
+	return next
+}
+
+// sameTile compares two versions of a tile, nil being a tile with nothing alive in it.
+func sameTile(a *tiles.Tile, b *tiles.Tile) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Cells, b.Cells)
+}
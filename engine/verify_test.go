@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestDisagree has a blinker turn over in the first tile of a 128x128 world and compares answers for it, the same
+// answer given as a whole tile and as flipped cells, and answers that really differ.
+func TestDisagree(t *testing.T) {
+	world := tiles.New(128, 128)
+	for _, cell := range []util.Cell{{X: 10, Y: 10}, {X: 11, Y: 10}, {X: 12, Y: 10}} {
+		world.Set(cell.X, cell.Y, 255)
+	}
+	first := tiles.Coord{X: 0, Y: 0}
+	turned := tiles.NewTile(0, 0)
+	for _, cell := range []util.Cell{{X: 11, Y: 9}, {X: 11, Y: 10}, {X: 11, Y: 11}} {
+		turned.Cells[cell.Y*tiles.Size+cell.X] = 255
+	}
+	flipped := []util.Cell{{X: 10, Y: 10}, {X: 12, Y: 10}, {X: 11, Y: 9}, {X: 11, Y: 11}}
+
+	whole := strip{tiles: []*tiles.Tile{turned}, changed: []tiles.Coord{first}}
+	cells := strip{flipped: flipped, changed: []tiles.Coord{first}}
+	extra := strip{flipped: append(append([]util.Cell(nil), flipped...), util.Cell{X: 20, Y: 20}), changed: []tiles.Coord{first}}
+	dead := strip{changed: []tiles.Coord{first}}
+	other := strip{flipped: append(append([]util.Cell(nil), flipped...), util.Cell{X: 100, Y: 100}),
+		changed: []tiles.Coord{first, {X: 1, Y: 1}}}
+
+	tests := []struct {
+		name   string
+		a, b   strip
+		differ []tiles.Coord
+	}{
+		{"whole tile and flipped cells", whole, cells, nil},
+		{"extra cell", whole, extra, []tiles.Coord{first}},
+		{"tile dying", cells, dead, []tiles.Coord{first}},
+		{"another tile changed", whole, other, []tiles.Coord{{X: 1, Y: 1}}},
+		{"nothing changed", strip{}, strip{}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differ := disagree(world, test.a, test.b)
+			if len(differ) != len(test.differ) {
+				t.Fatalf("expected the answers to differ on %v, got %v", test.differ, differ)
+			}
+			for i := range differ {
+				if differ[i] != test.differ[i] {
+					t.Fatalf("expected the answers to differ on %v, got %v", test.differ, differ)
+				}
+			}
+		})
+	}
+
+	// A changed tile sent back neither whole nor as flipped cells is left with nothing alive
+	if next := outcome(world, dead, []tiles.Coord{first}); next.Tiles[first] != nil {
+		t.Errorf("expected a changed tile that was not sent back to be empty, got %v", next.Tiles[first])
+	}
+	if next := outcome(world, cells, []tiles.Coord{first}); !sameTile(next.Tiles[first], turned) {
+		t.Errorf("expected the flipped cells to turn the blinker over")
+	}
+}
+
+// TestDivergenceLog checks a run keeps at most maxDivergences and hands out only the ones a caller has not had.
+func TestDivergenceLog(t *testing.T) {
+	var l divergenceLog
+	l.start("run", []stubs.Divergence{{Turn: 1}})
+	dropped := 0
+	for turn := 2; turn <= maxDivergences+5; turn++ {
+		dropped = l.add(stubs.Divergence{Turn: turn})
+	}
+	if len(l.list()) != maxDivergences || dropped != 5 {
+		t.Errorf("expected %d divergences kept and 5 dropped, got %d and %d", maxDivergences, len(l.list()), dropped)
+	}
+	since := l.since(maxDivergences-2, "run")
+	if len(since) != 2 || since[0].Turn != maxDivergences-1 {
+		t.Errorf("expected the last two divergences kept, got %v", since)
+	}
+	if len(l.since(0, "another run")) != 0 || len(l.since(maxDivergences, "")) != 0 {
+		t.Errorf("expected nothing for another run or a caller that has every divergence")
+	}
+}
+
+// TestVerifyUnchecked checks every turn a strip could only be worked out by one worker is counted as not verified.
+func TestVerifyUnchecked(t *testing.T) {
+	g := fakeBroker(&fakeWorker{}, &fakeWorker{})
+	g.Verify = true
+	if _, _, err := g.calculateTurn(1); err != nil {
+		t.Fatalf("expected the turn to be worked out, got %v", err)
+	}
+	if g.Divergences.unverified != 0 {
+		t.Errorf("expected the turn to be verified with both workers answering, got %d unverified", g.Divergences.unverified)
+	}
+
+	g = fakeBroker(&fakeWorker{}, &fakeWorker{fail: true})
+	g.Verify = true
+	for turn := 1; turn <= 2; turn++ {
+		if _, _, err := g.calculateTurn(turn); err != nil {
+			t.Fatalf("expected the turn to be worked out by the worker left, got %v", err)
+		}
+	}
+	if g.Divergences.unverified != 2 {
+		t.Errorf("expected both turns to be counted as not verified, got %d", g.Divergences.unverified)
+	}
+}
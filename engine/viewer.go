@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// viewerInterval is how often the viewer is sent the cells flipped since the last frame.
+const viewerInterval = 100 * time.Millisecond
+
+// viewerFrame is one server-sent event for the viewer. A full frame lists every alive cell, any other the cells
+// flipped since the frame before, both as x, y pairs. Turns in between frames are skipped.
+type viewerFrame struct {
+	Session  string `json:"session"`
+	Turn     int    `json:"turn"`
+	Full     bool   `json:"full"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Cells    []int  `json:"cells"`
+	Evolving bool   `json:"evolving"`
+	Paused   bool   `json:"paused"`
+	Alive    int    `json:"alive"`
+}
+
+// serveViewer adds a page that shows the run live in a browser, for watching without SDL. Its buttons use the
+// HTTP API, so only operators can pause, resume and quit from it. Browsers cannot send a token header when
+// loading a page or an event stream, so a token is given in the address instead: /viewer?token=...
+func (g *GOLWorker) serveViewer() {
+	transport.HandlePage("/viewer", g.endpoint("GET", stubs.Observer, "watch runs", func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := fmt.Fprint(w, viewerPage)
+		return err
+	}))
+	transport.HandlePage("/viewer/events", g.endpoint("GET", stubs.Observer, "watch runs", g.viewerEvents))
+}
+
+// viewerEvents streams the board as server-sent events until the browser goes away. The first frame of every
+// run is full, the rest only carry what flipped, and nothing is sent while nothing changes.
+func (g *GOLWorker) viewerEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("this connection cannot stream events")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(viewerInterval)
+	defer ticker.Stop()
+	var shown *tiles.World
+	var last viewerFrame
+	for {
+		state, world := g.replicaState()
+		status := g.status()
+		frame := viewerFrame{
+			Session:  state.Run.Session,
+			Turn:     state.Turn,
+			Evolving: status.Evolving,
+			Paused:   status.Paused,
+			Alive:    status.Alive,
+		}
+		send := true
+		switch {
+		case world == nil:
+			send = false
+		case shown == nil || frame.Session != last.Session:
+			frame.Full = true
+			frame.Width, frame.Height = state.Run.ImageWidth, state.Run.ImageHeight
+			frame.Cells = flatten(world.AliveCells())
+		case world != shown:
+			frame.Cells = flatten(shown.Flipped(world))
+		default:
+			send = frame.Evolving != last.Evolving || frame.Paused != last.Paused
+		}
+
+		if send {
+			data, err := json.Marshal(frame)
+			if err != nil {
+				return nil
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return nil
+			}
+			flusher.Flush()
+			shown, last = world, frame
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// flatten turns cells into x, y pairs, which take far less JSON than objects.
+func flatten(cells []util.Cell) []int {
+	flat := make([]int, 0, 2*len(cells))
+	for _, cell := range cells {
+		flat = append(flat, cell.X, cell.Y)
+	}
+	return flat
+}
+
+// viewerPage draws the frames from /viewer/events on a canvas, alive cells white as in the SDL window.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Game of Life</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #eee; margin: 1em; }
+button { margin-right: 0.5em; }
+#status { margin-left: 1em; }
+canvas { display: block; margin-top: 1em; image-rendering: pixelated; border: 1px solid #555; }
+</style>
+</head>
+<body>
+<div>
+<button id="pause">Pause</button><button id="save">Save</button><button id="quit">Quit</button>
+<span id="status">Connecting...</span>
+</div>
+<canvas id="board" width="1" height="1"></canvas>
+<script>
+var token = new URLSearchParams(location.search).get("token") || "";
+var query = token ? "?token=" + encodeURIComponent(token) : "";
+var canvas = document.getElementById("board");
+var context = canvas.getContext("2d");
+var statusText = document.getElementById("status");
+var pause = document.getElementById("pause");
+var image = null;
+var paused = false;
+
+// set makes the given cells alive, or flips them
+function set(cells, flip) {
+	for (var i = 0; i < cells.length; i += 2) {
+		var x = cells[i], y = cells[i + 1];
+		if (x < 0 || y < 0 || x >= image.width || y >= image.height) {
+			continue;
+		}
+		var p = (y * image.width + x) * 4;
+		var v = flip ? 255 - image.data[p] : 255;
+		image.data[p] = image.data[p + 1] = image.data[p + 2] = v;
+	}
+}
+
+var events = new EventSource("/viewer/events" + query);
+events.onmessage = function (e) {
+	var frame = JSON.parse(e.data);
+	if (frame.full) {
+		canvas.width = frame.width;
+		canvas.height = frame.height;
+		var scale = Math.max(1, Math.floor(800 / Math.max(frame.width, frame.height)));
+		canvas.style.width = frame.width * scale + "px";
+		image = context.createImageData(frame.width, frame.height);
+		for (var i = 3; i < image.data.length; i += 4) {
+			image.data[i] = 255;
+		}
+		set(frame.cells, false);
+	} else if (image) {
+		set(frame.cells, true);
+	}
+	if (image) {
+		context.putImageData(image, 0, 0);
+	}
+	paused = frame.paused;
+	pause.textContent = paused ? "Resume" : "Pause";
+	statusText.textContent = "Turn " + frame.turn + ", " + frame.alive + " alive, " +
+		(paused ? "paused" : frame.evolving ? "running" : "finished");
+};
+events.onerror = function () {
+	statusText.textContent = "Lost the broker, reconnecting...";
+};
+
+// call makes a request to the HTTP API, showing any error it answers with
+function call(path) {
+	fetch(path, {method: "POST", headers: {"X-Gol-Token": token}})
+		.then(function (r) { return r.json(); })
+		.then(function (answer) {
+			if (answer.error) {
+				alert(answer.error);
+			}
+		});
+}
+pause.onclick = function () { call(paused ? "/api/resume" : "/api/pause"); };
+document.getElementById("quit").onclick = function () { call("/api/quit"); };
+// save downloads the board, sending the token as a header since the API does not take it from the address
+document.getElementById("save").onclick = function () {
+	fetch("/api/snapshot", {headers: {"X-Gol-Token": token}})
+		.then(function (r) {
+			if (!r.ok) {
+				return r.json().then(function (answer) { throw new Error(answer.error); });
+			}
+			var name = /filename="([^"]+)"/.exec(r.headers.get("Content-Disposition") || "");
+			return r.blob().then(function (blob) {
+				var link = document.createElement("a");
+				link.href = URL.createObjectURL(blob);
+				link.download = name ? name[1] : "snapshot.pgm";
+				link.click();
+				setTimeout(function () { URL.revokeObjectURL(link.href); }, 0);
+			});
+		})
+		.catch(function (err) { alert(err.message); });
+};
+</script>
+</body>
+</html>
+`
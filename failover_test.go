@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// brokers builds the broker, returning a function that starts one with the given flags in engine, so it uses the
+// workers in engine/workers.txt, and one that cleans up after them.
+func brokers(t *testing.T) (func(args ...string) *exec.Cmd, func()) {
+	dir, err := ioutil.TempDir("", "gol-failover")
+	util.Check(err)
+	broker := filepath.Join(dir, "broker")
+	build := exec.Command("go", "build", "-o", broker, "./engine")
+	if out, err := build.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("could not build the broker: %v\n%s", err, out)
+	}
+	start := func(args ...string) *exec.Cmd {
+		cmd := exec.Command(broker, args...)
+		cmd.Dir = "engine"
+		util.Check(cmd.Start())
+		return cmd
+	}
+	return start, func() { os.RemoveAll(dir) }
+}
+
+// TestFailover starts a broker of its own with a standby following it, kills the broker part way through a
+// 512x512 run and checks the standby finishes it with the right board. It uses the workers in engine/workers.txt.
+func TestFailover(t *testing.T) {
+	start, done := brokers(t)
+	defer done()
+	primary := start("-port", "8032")
+	defer primary.Process.Kill()
+	standby := start("-port", "8033", "-standby", "127.0.0.1:8032", "-failover", "1s")
+	defer standby.Process.Kill()
+	time.Sleep(500 * time.Millisecond)
+
+	p := gol.Params{
+		ImageWidth:  512,
+		ImageHeight: 512,
+		Turns:       100,
+		Threads:     4,
+		Server:      "127.0.0.1:8032",
+		Standby:     "127.0.0.1:8033",
+	}
+	expectedAlive := readAliveCells("check/images/512x512x100.pgm", p.ImageWidth, p.ImageHeight)
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	killed := false
+	for event := range events {
+		switch e := event.(type) {
+		case gol.TurnComplete:
+			if !killed && e.CompletedTurns >= 20 {
+				util.Check(primary.Process.Kill())
+				primary.Wait()
+				killed = true
+			}
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	if !killed {
+		t.Fatal("expected the run to get far enough to kill the broker")
+	}
+	assertEqualBoard(t, cells, expectedAlive, p)
+}
+
+// TestFailoverDiesOut has the primary run a board that dies out straight away and checks the standby follows it
+// to an empty world, rather than keeping the live board from the run before, and carries on from there once the
+// primary is killed.
+func TestFailoverDiesOut(t *testing.T) {
+	start, done := brokers(t)
+	defer done()
+	primary := start("-port", "8034")
+	defer primary.Process.Kill()
+	standby := start("-port", "8035", "-standby", "127.0.0.1:8034", "-failover", "1s")
+	defer standby.Process.Kill()
+	time.Sleep(500 * time.Millisecond)
+
+	post := func(path string, body string) {
+		res, err := http.Post("http://127.0.0.1:8034"+path, "text/plain", strings.NewReader(body))
+		util.Check(err)
+		res.Body.Close()
+	}
+	alive := func() *stubs.AliveCellsCountResponse {
+		client, err := transport.Dial(transport.Gob, "127.0.0.1:8035")
+		util.Check(err)
+		defer client.Close()
+		res := &stubs.AliveCellsCountResponse{}
+		util.Check(client.Call(stubs.AliveCellsCountHandler, stubs.Empty{}, res))
+		return res
+	}
+
+	// A block the standby picks up, then a single cell that dies on the first turn
+	post("/api/runs?turns=1&width=64&height=64", "x = 2, y = 2\n2o$2o!")
+	time.Sleep(time.Second)
+	if count := alive().AliveCellsCount; count != 4 {
+		t.Fatalf("expected the standby to have the block, got %d alive", count)
+	}
+	const turns = 100000
+	post(fmt.Sprintf("/api/runs?turns=%d&width=64&height=64", turns), "x = 1, y = 1\no!")
+	for paused := false; !paused; {
+		time.Sleep(10 * time.Millisecond)
+		res, err := http.Post("http://127.0.0.1:8034/api/pause", "", nil)
+		util.Check(err)
+		var status struct{ Turn int }
+		json.NewDecoder(res.Body).Decode(&status)
+		res.Body.Close()
+		paused = res.StatusCode == http.StatusOK && status.Turn > 0
+		if res.StatusCode == http.StatusOK && !paused {
+			post("/api/resume", "")
+		}
+	}
+	time.Sleep(time.Second)
+	if count := alive().AliveCellsCount; count != 0 {
+		t.Errorf("expected the standby to follow the board dying out, got %d alive", count)
+	}
+
+	util.Check(primary.Process.Kill())
+	primary.Wait()
+	deadline := time.Now().Add(30 * time.Second)
+	res := alive()
+	for (res.Evolving || res.CompletedTurns != turns) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		res = alive()
+	}
+	if res.CompletedTurns != turns || res.AliveCellsCount != 0 {
+		t.Errorf("expected the standby to finish %d turns with nothing alive, got %d turns with %d alive", turns, res.CompletedTurns, res.AliveCellsCount)
+	}
+}
@@ -2,10 +2,15 @@ package gol
 
 import (
 	"fmt"
-	"log"
 	"net/rpc"
+	"os"
+	"strings"
 	"time"
+	"uk.ac.bris.cs/gameoflife/logging"
 	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/tracing"
+	"uk.ac.bris.cs/gameoflife/transport"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -14,147 +19,247 @@ type distributorChannels struct {
 	ioCommand  chan<- ioCommand
 	ioIdle     <-chan bool
 	ioFilename chan<- string
-	ioOutput   chan<- uint8
-	ioInput    <-chan uint8
+	ioBounds   chan<- tiles.Bounds
+	ioOutput   chan<- []uint8
+	ioInput    <-chan []uint8
 	keyPresses <-chan rune
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p Params, c distributorChannels) {
 
-	c.ioCommand <- ioInput
-	c.ioFilename <- fmt.Sprintf("%d%s%d", p.ImageWidth, "x", p.ImageHeight)
+	// Every node logs the run under the same session
+	session := logging.NewSession()
+	log := logging.With("session", session)
+
+	// Connect to the server via RPC, there is none when running on peers
+	var client transport.Client
+	var err error
+	if len(p.Peers) == 0 {
+		if p.Server == "" {
+			p.Server = "127.0.0.1:8030"
+		}
+		client, err = transport.Dial(p.Transport, p.Server)
+		if err != nil {
+			log.Fatal("could not connect to the server", "err", err)
+		}
 
-	// TODO: Create a 2D slice to store the world.
-	world := make([][]uint8, p.ImageHeight)
-	for i := range world {
-		world[i] = make([]uint8, p.ImageWidth)
-		for j := 0; j < p.ImageWidth; j++ {
-			world[i][j] = <-c.ioInput
+		// Observers follow whatever the server is running instead of starting a run from an image
+		role := &stubs.RoleResponse{}
+		err = client.Call(stubs.RoleHandler, stubs.Empty{}, role)
+		if err != nil {
+			log.Fatal("call failed", "method", stubs.RoleHandler, "err", err)
+		}
+		if role.Role == stubs.Observer {
+			observe(p, c, client, logging.With("role", stubs.Observer))
+			return
 		}
 	}
 
-	// Send CellFlipped events for any initial live cells in the world.
-	for i := range world {
-		for j := range world[i] {
-			if world[i][j] == 255 {
-				c.events <- CellFlipped{0, util.Cell{j, i}}
+	c.ioCommand <- ioInput
+	c.ioFilename <- fmt.Sprintf("%d%s%d", p.ImageWidth, "x", p.ImageHeight)
+
+	// The world is kept as tiles so unbounded runs can be saved around wherever the cells have moved to.
+	// Each row goes into the tiles as it comes in, so the board is never held densely.
+	board := tiles.New(p.ImageWidth, p.ImageHeight)
+	board.Unbounded = p.Unbounded
+	for y := 0; y < p.ImageHeight; y++ {
+		row := <-c.ioInput
+		board.SetRow(y, row)
+
+		// Send CellFlipped events for any initial live cells in the world.
+		for x, cell := range row {
+			if cell == 255 {
+				c.events <- CellFlipped{0, util.Cell{X: x, Y: y}}
 			}
 		}
 	}
+	if len(p.Peers) > 0 {
+		runPeers(p, c, board, session, log)
+		return
+	}
 
 	turn := 0
-	// Connect to the server via RPC
-	client, err := rpc.Dial("tcp", "127.0.0.1:8030") // Replace "127.0.0.1:8030" with your server's IP and port
-	if err != nil {
-		log.Fatal("Error connecting to server:", err)
-	}
 	// golWorker := new(engine.GOLWorker)
 	//request to make to server for evolving the world
 	evolveRequest := stubs.EvolveWorldRequest{
-		World:       world,
-		Width:       p.ImageWidth,
-		Height:      p.ImageHeight,
-		Turn:        p.Turns,
-		Threads:     p.Threads,
-		ImageWidth:  p.ImageWidth,
-		ImageHeight: p.ImageHeight,
+		Tiles:         board.List(),
+		Width:         p.ImageWidth,
+		Height:        p.ImageHeight,
+		Turn:          p.Turns,
+		Threads:       p.Threads,
+		ImageWidth:    p.ImageWidth,
+		ImageHeight:   p.ImageHeight,
+		Unbounded:     p.Unbounded,
+		Engine:        p.Engine,
+		OnCycle:       p.OnCycle,
+		CellEvents:    true,
+		Session:       session,
+		Trace:         p.TraceFile != "",
+		Decomposition: p.Decomposition,
+		TurnsPerCall:  p.TurnsPerCall,
+		Verify:        p.Verify,
 	}
+	log.Info("starting run", "width", p.ImageWidth, "height", p.ImageHeight, "turns", p.Turns, "engine", p.Engine)
 	evolveResponse := &stubs.EvolveResponse{}
 
-	go func() {
-		ticker := time.NewTicker(2 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				empty := stubs.Empty{}
-				aliveCellsCountResponse := &stubs.AliveCellsCountResponse{}
-
-				err = client.Call(stubs.AliveCellsCountHandler, empty, aliveCellsCountResponse)
-				if err != nil {
-					log.Fatal("call error : ", err)
-					return
-				}
-				numberAliveCells := aliveCellsCountResponse.AliveCellsCount
-				turn := aliveCellsCountResponse.CompletedTurns
-
-				c.events <- AliveCellsCount{turn, numberAliveCells}
-				// Check for keypress events
-			case command := <-c.keyPresses:
-				// React based on the keypress command
-				empty := stubs.Empty{}
-				emptyResponse := &stubs.Empty{}
-				getGlobal := &stubs.GetGlobalResponse{}
-				err = client.Call(stubs.GetGlobalHandler, empty, getGlobal)
-				if err != nil {
-					log.Fatal("call error : ", err)
+	killed := false
+
+	// reportCycle sends CycleDetected the first time the server says the world repeats.
+	cycleReported := false
+	reportCycle := func(period int, periodTurn int) {
+		if period > 0 && !cycleReported {
+			cycleReported = true
+			c.events <- CycleDetected{periodTurn, period}
+		}
+	}
+
+	// lost handles a call failing while the run goes on. With a standby the run carries on there once the call
+	// evolving the world fails as well, so the failure is only logged.
+	lost := func(method string, err error) {
+		if p.Standby == "" {
+			log.Fatal("call failed", "method", method, "err", err)
+		}
+		log.Warn("call failed", "method", method, "err", err)
+	}
+
+	// The board as drawn by the events sent so far, carried over if the run moves to the standby
+	shown := &shownBoard{board.Copy(), 0, 0}
+	method, request := stubs.EvolveWorldHandler, interface{}(evolveRequest)
+	for {
+		// done is closed once the server has finished evolving, stopped is closed once the goroutine below has returned.
+		done := make(chan bool)
+		stopped := make(chan bool)
+		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
 					return
-				}
-				world = getGlobal.World
-				turn = getGlobal.Turns
-
-				switch command {
-				case 's': // 's' key is pressed
-					// StateChange event to indicate execution and save a PGM image
-					c.events <- StateChange{turn, Executing}
-					savePGMImage(c, world, p) // Function to save the current state as a PGM image
-
-				case 'q': // 'q' key is pressed
-					// StateChange event to indicate quitting and save a PGM image
-					err = client.Call(stubs.QuitHandler, empty, emptyResponse)
-					c.events <- StateChange{turn, Quitting}
-					savePGMImage(c, world, p) // Function to save the current state as a PGM image
-					close(c.events)           // Close the events channel
-
-				case 'k':
-					err = client.Call(stubs.KillServerHandler, empty, emptyResponse)
-					c.events <- StateChange{turn, Quitting}
-					savePGMImage(c, world, p) // Function to save the current state as a PGM image
-					close(c.events)           // Close the events channel
-
-				case 'p': // 'p' key is pressed
-					c.events <- StateChange{turn, Paused}
-					err = client.Call(stubs.PauseHandler, empty, emptyResponse)
-					fmt.Printf("Current turn %d being processed\n", turn)
-					for {
-						if <-c.keyPresses == 'p' {
-							err = client.Call(stubs.UnpauseHandler, empty, emptyResponse)
-							break
+				case <-ticker.C:
+					empty := stubs.Empty{}
+					aliveCellsCountResponse := &stubs.AliveCellsCountResponse{}
+
+					err := client.Call(stubs.AliveCellsCountHandler, empty, aliveCellsCountResponse)
+					if err != nil {
+						lost(stubs.AliveCellsCountHandler, err)
+						return
+					}
+					numberAliveCells := aliveCellsCountResponse.AliveCellsCount
+					turn := aliveCellsCountResponse.CompletedTurns
+
+					c.events <- AliveCellsCount{turn, numberAliveCells}
+					reportCycle(aliveCellsCountResponse.Period, aliveCellsCountResponse.PeriodTurn)
+					// Check for keypress events
+				case command := <-c.keyPresses:
+					// React based on the keypress command
+					empty := stubs.Empty{}
+					emptyResponse := &stubs.Empty{}
+					getTiles := &stubs.GetTilesResponse{}
+					err := client.Call(stubs.GetTilesHandler, empty, getTiles)
+					if err != nil {
+						lost(stubs.GetTilesHandler, err)
+						return
+					}
+					board = tiles.FromList(getTiles.Width, getTiles.Height, getTiles.Tiles)
+					board.Unbounded = getTiles.Unbounded
+					turn = getTiles.Turns
+
+					switch command {
+					case 's': // 's' key is pressed
+						// StateChange event to indicate execution and save a PGM image
+						c.events <- StateChange{turn, Executing}
+						savePGMImage(c, board, turn, p) // Function to save the current state as a PGM image
+
+					case 'q': // 'q' key is pressed
+						// Stop the server, the final state is reported and saved once EvolveWorld returns
+						err = client.Call(stubs.QuitHandler, empty, emptyResponse)
+						if err != nil {
+							log.Fatal("call failed", "method", stubs.QuitHandler, "err", err)
+						}
+						return
+
+					case 'k':
+						// Save the current state first as the server will not answer once it has been killed
+						savePGMImage(c, board, turn, p)
+						killed = true
+						_ = client.Call(stubs.KillServerHandler, empty, emptyResponse)
+						return
+
+					case 'p': // 'p' key is pressed
+						c.events <- StateChange{turn, Paused}
+						err = client.Call(stubs.PauseHandler, empty, emptyResponse)
+						log.Info("paused", "turn", turn)
+						for {
+							if <-c.keyPresses == 'p' {
+								err = client.Call(stubs.UnpauseHandler, empty, emptyResponse)
+								break
+							}
 						}
+						// StateChange event to indicate execution after pausing
+						c.events <- StateChange{turn, Executing}
 					}
-					// StateChange event to indicate execution after pausing
-					c.events <- StateChange{turn, Executing}
-				}
-			default: // No events
-				if turn == p.Turns {
-					return
 				}
 			}
+		}()
+		// Send CellFlipped and TurnComplete events for every turn the server finishes.
+		flipsStopped := make(chan bool)
+		go streamFlips(p, c, client, shown, session, done, flipsStopped)
+
+		// gob leaves out fields that are zero, so a response reused after failing over could keep stale ones
+		evolveResponse = &stubs.EvolveResponse{}
+		err = client.Call(method, request, evolveResponse)
+		close(done)
+		<-stopped
+		<-flipsStopped
+		// An error sent back by the server is the run failing, which the standby would not get any further with
+		_, failed := err.(rpc.ServerError)
+		if err == nil || killed || failed || p.Standby == "" {
+			break
+		}
+
+		// The standby carries the run on from the last turn it heard of once it has taken over, which Resume waits for
+		log.Warn("lost the server, carrying on with the standby", "standby", p.Standby, "turn", shown.turn, "err", err)
+		client.Close()
+		client, err = transport.Dial(p.Transport, p.Standby)
+		if err != nil {
+			log.Fatal("could not connect to the standby", "standby", p.Standby, "err", err)
 		}
-	}()
-	err = client.Call(stubs.EvolveWorldHandler, evolveRequest, evolveResponse)
+		method, request = stubs.ResumeHandler, stubs.Empty{}
+	}
 	if err != nil {
-		log.Fatal("call error : ", err)
+		if !killed {
+			log.Fatal("call failed", "method", method, "err", err)
+		}
+		// The server went away after 'k', finish with the state saved before killing it
+		c.events <- FinalTurnComplete{turn, board.AliveCells()}
+		c.events <- StateChange{turn, Quitting}
+		close(c.events)
+		return
 	}
-	world = evolveResponse.World
+	board = tiles.FromList(p.ImageWidth, p.ImageHeight, evolveResponse.Tiles)
+	board.Unbounded = p.Unbounded
 	turn = evolveResponse.Turn
+	reportCycle(evolveResponse.Period, evolveResponse.PeriodTurn)
 
-	aliveCellsRequest := stubs.CalculateAliveCellsRequest{
-		World: world,
-	}
+	aliveCellsRequest := stubs.Empty{}
 
 	aliveCellsResponse := &stubs.CalculateAliveCellsResponse{}
 
 	err = client.Call(stubs.AliveCellsHandler, aliveCellsRequest, aliveCellsResponse)
 	if err != nil {
-		log.Fatal("call error : ", err)
+		log.Fatal("call failed", "method", stubs.AliveCellsHandler, "err", err)
 	}
 	aliveCells := aliveCellsResponse.AliveCells
+	if p.TraceFile != "" {
+		writeTrace(p.TraceFile, client, log)
+	}
 
 	// TODO: Report the final state using FinalTurnCompleteEvent.
 	c.events <- FinalTurnComplete{turn, aliveCells}
-	savePGMImage(c, world, p)
+	savePGMImage(c, board, turn, p)
 
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
@@ -166,14 +271,113 @@ func distributor(p Params, c distributorChannels) {
 	close(c.events)
 }
 
-func savePGMImage(c distributorChannels, world [][]byte, p Params) {
-	c.ioCommand <- ioOutput
-	c.ioFilename <- fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+// shownBoard is the board as the events sent so far have drawn it, after turn, and how many divergences
+// have been reported.
+type shownBoard struct {
+	world       *tiles.World
+	turn        int
+	divergences int
+}
 
-	// Iterate over the world and send each cell's value to the ioOutput channel for writing the PGM image
-	for i := range world {
-		for j := range world[i] {
-			c.ioOutput <- world[i][j] // Send the current cell value to the output channel
+// streamFlips fetches the cells flipped each turn after the shown one from the server and sends them on as events,
+// keeping the shown board up to date to work out what flipped when the server jumped over turns. Cells outside the
+// image are not sent as there is nowhere to show them. The owner is the controller that started the run and
+// gives its session, observers give none and poll less often as the server does not wait for them. Divergences
+// come with the flipped cells and are sent on as DivergenceDetected as soon as the server has found them.
+func streamFlips(p Params, c distributorChannels, client transport.Client, shown *shownBoard, session string, done <-chan bool, stopped chan<- bool) {
+	owner := session != ""
+	defer close(stopped)
+	for {
+		// Once the server has finished one more fetch gets whatever turns are left
+		finished := false
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		flips := &stubs.GetFlippedResponse{}
+		req := stubs.GetFlippedRequest{After: shown.turn, Owner: owner, Session: session, Divergences: shown.divergences}
+		err := client.Call(stubs.GetFlippedHandler, req, flips)
+		if err != nil {
+			return
+		}
+		for _, d := range flips.Divergences {
+			logging.Error("workers disagree", "session", session, "turn", d.Turn, "rows", fmt.Sprintf("%d-%d", d.StartRow, d.EndRow),
+				"workers", strings.Join(d.Workers, ","))
+			c.events <- DivergenceDetected{d.Turn, d.StartRow, d.EndRow, d.StartCol, d.EndCol, d.Workers}
+			shown.divergences++
+		}
+		for _, t := range flips.Turns {
+			flipped := t.Flipped
+			if t.Resync {
+				next := tiles.FromList(p.ImageWidth, p.ImageHeight, t.Tiles)
+				next.Unbounded = p.Unbounded
+				flipped = shown.world.Flipped(next)
+				shown.world = next
+			} else {
+				shown.world.Flip(flipped)
+			}
+			for _, cell := range flipped {
+				if cell.X >= 0 && cell.X < p.ImageWidth && cell.Y >= 0 && cell.Y < p.ImageHeight {
+					c.events <- CellFlipped{t.Turn, cell}
+				}
+			}
+			c.events <- TurnComplete{t.Turn}
+			shown.turn = t.Turn
+		}
+		if finished {
+			return
 		}
+		if !owner {
+			time.Sleep(100 * time.Millisecond)
+		} else if len(flips.Turns) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// writeTrace fetches the spans of the run from the server and writes them as a Chrome trace,
+// which can be opened in chrome://tracing or Perfetto.
+func writeTrace(filename string, client transport.Client, log *logging.Logger) {
+	trace := &stubs.GetTraceResponse{}
+	err := client.Call(stubs.GetTraceHandler, stubs.Empty{}, trace)
+	if err != nil {
+		log.Error("call failed", "method", stubs.GetTraceHandler, "err", err)
+		return
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Error("could not write trace", "file", filename, "err", err)
+		return
+	}
+	defer file.Close()
+	err = tracing.WriteChrome(file, trace.Spans)
+	if err != nil {
+		log.Error("could not write trace", "file", filename, "err", err)
+		return
 	}
+	log.Info("trace written", "file", filename, "spans", len(trace.Spans))
+}
+
+// savePGMImage sends the world to the io goroutine and reports ImageOutputComplete once the file is synced.
+// Unbounded worlds are saved as the box around their alive cells.
+func savePGMImage(c distributorChannels, board *tiles.World, turn int, p Params) {
+	filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
+	bounds := board.Bounds()
+	c.ioCommand <- ioOutput
+	c.ioFilename <- filename
+	c.ioBounds <- bounds
+
+	// Send the world row by row to the ioOutput channel for writing the PGM image
+	_ = board.EachRow(bounds, func(row []byte) error {
+		c.ioOutput <- row
+		return nil
+	})
+
+	// The io goroutine only answers once the previous command, and so the file sync, is done
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+
+	c.events <- ImageOutputComplete{turn, filename}
 }
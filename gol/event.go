@@ -52,6 +52,31 @@ type CellFlipped struct { // implements Event
 	Cell           util.Cell
 }
 
+// CycleDetected is an Event notifying the user that the world has started repeating itself.
+// Period is the number of turns between repeats, 1 for a still life.
+// This Event is sent once, the first time the repeat is noticed.
+type CycleDetected struct { // implements Event
+	CompletedTurns int
+	Period         int
+}
+
+// DivergenceDetected is an Event notifying the user that two workers worked out part of a turn differently.
+// It is only sent when results are being verified, for the cell rows and columns of the tiles they disagreed on.
+type DivergenceDetected struct { // implements Event
+	CompletedTurns int
+	StartRow       int
+	EndRow         int
+	StartCol       int
+	EndCol         int
+	Workers        []string
+}
+
+// Observing is an Event notifying the user that the controller has connected as an observer.
+// Observers follow the run already going on the server and cannot pause, quit or kill it.
+type Observing struct { // implements Event
+	CompletedTurns int
+}
+
 // TurnComplete is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All CellFlipped events must be sent *before* TurnComplete.
@@ -114,6 +139,30 @@ func (event CellFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CycleDetected) String() string {
+	return fmt.Sprintf("Repeating every %v turns", event.Period)
+}
+
+func (event CycleDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event DivergenceDetected) String() string {
+	return fmt.Sprintf("Workers %v disagree on rows %v-%v, columns %v-%v", event.Workers, event.StartRow, event.EndRow, event.StartCol, event.EndCol)
+}
+
+func (event DivergenceDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event Observing) String() string {
+	return "Connected as an observer, following the current run"
+}
+
+func (event Observing) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return fmt.Sprintf("")
 }
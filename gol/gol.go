@@ -1,11 +1,34 @@
 package gol
 
+import (
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Unbounded   bool
+	Engine      string
+	OnCycle     string
+	Transport   string
+	// Decomposition is how the board is split between the workers, strips or blocks
+	Decomposition string
+	// TurnsPerCall is how many turns the workers work out each time they are called, 1 when 0.
+	TurnsPerCall int
+	// Verify has every strip worked out twice, reporting DivergenceDetected where the workers disagree
+	Verify bool
+	// Server is the broker to connect to, 127.0.0.1:8030 when empty. Standby is the broker that takes the run
+	// over if Server stops answering, there is none when empty.
+	Server  string
+	Standby string
+	// Peers are workers to run on without a broker, Server and Standby are not used when there are any
+	Peers []string
+	// TraceFile is where to write a Chrome trace of every turn, nothing is traced when empty
+	TraceFile string
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
@@ -16,15 +39,17 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioCommand := make(chan ioCommand)
 	ioIdle := make(chan bool)
 	ioFilename := make(chan string)
-	ioOutput := make(chan uint8)
-	ioInput := make(chan uint8)
+	ioBounds := make(chan tiles.Bounds)
+	ioOutput := make(chan []uint8)
+	ioInput := make(chan []uint8)
 
-	print(p.Threads)
+	logging.Debug("starting distributor", "threads", p.Threads, "width", p.ImageWidth, "height", p.ImageHeight, "transport", p.Transport)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
 		idle:     ioIdle,
 		filename: ioFilename,
+		bounds:   ioBounds,
 		output:   ioOutput,
 		input:    ioInput,
 	}
@@ -36,6 +61,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioCommand:  ioCommand,
 		ioIdle:     ioIdle,
 		ioFilename: ioFilename,
+		ioBounds:   ioBounds,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
 		keyPresses: keyPresses,
@@ -1,11 +1,12 @@
 package gol
 
 import (
+	"bufio"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strconv"
-	"strings"
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/tiles"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -14,8 +15,9 @@ type ioChannels struct {
 	idle    chan<- bool
 
 	filename <-chan string
-	output   <-chan uint8
-	input    chan<- uint8
+	bounds   <-chan tiles.Bounds
+	output   <-chan []uint8
+	input    chan<- []uint8
 }
 
 // ioState is the internal ioState of the io goroutine.
@@ -38,91 +40,101 @@ const (
 	ioCheckIdle
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
+// writePgmImage receives the world row by row and writes it to a pgm file.
 func (io *ioState) writePgmImage() {
 	_ = os.Mkdir("out", os.ModePerm)
 
-	// Request a filename from the distributor.
+	// Request a filename and the part of the board to write from the distributor.
 	filename := <-io.channels.filename
+	bounds := <-io.channels.bounds
 
 	file, ioError := os.Create("out/" + filename + ".pgm")
 	util.Check(ioError)
 	defer file.Close()
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
-
-	world := make([][]byte, io.params.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, io.params.ImageWidth)
-	}
+	// Buffer the writes so the whole image goes to the file in a few large chunks.
+	writer := bufio.NewWriter(file)
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			val := <-io.channels.output
-			//if val != 0 {
-			//	fmt.Println(x, y)
-			//}
-			world[y][x] = val
-		}
+	_, _ = writer.WriteString("P5\n")
+	//_, _ = writer.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
+	if io.params.Unbounded {
+		// Unbounded images only cover the alive cells, the comment says where their top left corner is.
+		_, _ = writer.WriteString(fmt.Sprintf("# offset %d %d\n", bounds.X, bounds.Y))
 	}
-
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
+	_, _ = writer.WriteString(strconv.Itoa(bounds.Width))
+	_, _ = writer.WriteString(" ")
+	_, _ = writer.WriteString(strconv.Itoa(bounds.Height))
+	_, _ = writer.WriteString("\n")
+	_, _ = writer.WriteString(strconv.Itoa(255))
+	_, _ = writer.WriteString("\n")
+
+	for y := 0; y < bounds.Height; y++ {
+		row := <-io.channels.output
+		if len(row) != bounds.Width {
+			panic("Incorrect row width")
 		}
+		_, ioError = writer.Write(row)
+		util.Check(ioError)
 	}
 
+	ioError = writer.Flush()
+	util.Check(ioError)
+
 	ioError = file.Sync()
 	util.Check(ioError)
 
-	fmt.Println("File", filename, "output done!")
+	logging.Info("image written", "file", filename)
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
+// readPgmImage opens a pgm file and sends its data row by row. Only a row is read in at a time, so images too
+// large to fit in memory can still be loaded into tiles.
 func (io *ioState) readPgmImage() {
 
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
+	file, ioError := os.Open("images/" + filename + ".pgm")
 	util.Check(ioError)
+	defer file.Close()
+	reader := bufio.NewReader(file)
 
-	fields := strings.Fields(string(data))
+	var magic string
+	var width, height, maxval int
+	_, ioError = fmt.Fscan(reader, &magic, &width, &height, &maxval)
+	util.Check(ioError)
 
-	if fields[0] != "P5" {
+	if magic != "P5" {
 		panic("Not a pgm file")
 	}
 
-	width, _ := strconv.Atoi(fields[1])
 	if width != io.params.ImageWidth {
 		panic("Incorrect width")
 	}
 
-	height, _ := strconv.Atoi(fields[2])
 	if height != io.params.ImageHeight {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
 	if maxval != 255 {
 		panic("Incorrect maxval/bit depth")
 	}
 
-	image := []byte(fields[4])
+	// A single whitespace character separates the header from the cells
+	_, ioError = reader.ReadByte()
+	util.Check(ioError)
 
-	for _, b := range image {
-		io.channels.input <- b
+	// Each row gets its own slice as the distributor may still be reading the last one.
+	for y := 0; y < height; y++ {
+		row := make([]byte, width)
+		for read := 0; read < width; {
+			n, ioError := reader.Read(row[read:])
+			util.Check(ioError)
+			read += n
+		}
+		io.channels.input <- row
 	}
 
-	fmt.Println("File", filename, "input done!")
+	logging.Info("image read", "file", filename)
 }
 
 // startIo should be the entrypoint of the io goroutine.
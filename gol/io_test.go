@@ -0,0 +1,80 @@
+package gol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var pgmSizes = []int{16, 64, 128, 256, 512}
+
+// inRoot moves to the top of the repository, where images/ and out/ are, and silences the log. It returns
+// a function that undoes both. These benchmarks drive the unexported io goroutine directly so they live here,
+// while BenchmarkPgm in pgm_test.go measures whole runs with no turns.
+func inRoot() func() {
+	logging.SetOutput(ioutil.Discard)
+	util.Check(os.Chdir(".."))
+	return func() {
+		util.Check(os.Chdir("gol"))
+		logging.SetOutput(os.Stderr)
+	}
+}
+
+// BenchmarkReadPgm measures the io goroutine reading images and handing them over row by row.
+func BenchmarkReadPgm(b *testing.B) {
+	defer inRoot()()
+	for _, size := range pgmSizes {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			filename := make(chan string)
+			input := make(chan []uint8)
+			io := ioState{
+				params:   Params{ImageWidth: size, ImageHeight: size},
+				channels: ioChannels{filename: filename, input: input},
+			}
+			for i := 0; i < b.N; i++ {
+				go io.readPgmImage()
+				filename <- fmt.Sprintf("%dx%d", size, size)
+				for y := 0; y < size; y++ {
+					<-input
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWritePgm measures the io goroutine taking a board row by row and writing it out as an image.
+func BenchmarkWritePgm(b *testing.B) {
+	defer inRoot()()
+	for _, size := range pgmSizes {
+		name := fmt.Sprintf("%dx%d-benchmark", size, size)
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			filename := make(chan string)
+			bounds := make(chan tiles.Bounds)
+			output := make(chan []uint8)
+			io := ioState{
+				params:   Params{ImageWidth: size, ImageHeight: size},
+				channels: ioChannels{filename: filename, bounds: bounds, output: output},
+			}
+			row := make([]uint8, size)
+			for i := 0; i < b.N; i++ {
+				done := make(chan bool)
+				go func() {
+					io.writePgmImage()
+					close(done)
+				}()
+				filename <- name
+				bounds <- tiles.Bounds{Width: size, Height: size}
+				for y := 0; y < size; y++ {
+					output <- row
+				}
+				<-done
+			}
+		})
+		_ = os.Remove("out/" + name + ".pgm")
+	}
+}
@@ -0,0 +1,83 @@
+package gol
+
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// observe follows the run going on the server until it finishes. Observers get the same events as the
+// controller that started the run and can save snapshots with 's' or stop following with 'q'.
+// Pausing and killing are still sent so the server can say why they are not allowed.
+func observe(p Params, c distributorChannels, client transport.Client, log *logging.Logger) {
+	empty := stubs.Empty{}
+	count := &stubs.AliveCellsCountResponse{}
+	err := client.Call(stubs.AliveCellsCountHandler, empty, count)
+	if err != nil {
+		log.Fatal("call failed", "method", stubs.AliveCellsCountHandler, "err", err)
+	}
+	log.Info("observing", "turn", count.CompletedTurns, "evolving", count.Evolving)
+	c.events <- Observing{count.CompletedTurns}
+
+	done := make(chan bool)
+	flipsStopped := make(chan bool)
+	shown := &shownBoard{tiles.New(p.ImageWidth, p.ImageHeight), -1, 0}
+	shown.world.Unbounded = p.Unbounded
+	go streamFlips(p, c, client, shown, "", done, flipsStopped)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	following := count.Evolving
+	for following {
+		select {
+		case <-ticker.C:
+			// gob leaves out fields that are false, so a reused response would never stop evolving
+			count = &stubs.AliveCellsCountResponse{}
+			err := client.Call(stubs.AliveCellsCountHandler, empty, count)
+			if err != nil {
+				log.Fatal("call failed", "method", stubs.AliveCellsCountHandler, "err", err)
+			}
+			c.events <- AliveCellsCount{count.CompletedTurns, count.AliveCellsCount}
+			following = count.Evolving
+		case command := <-c.keyPresses:
+			switch command {
+			case 's':
+				board, turn := fetchBoard(client, log)
+				savePGMImage(c, board, turn, p)
+			case 'q':
+				following = false
+			case 'p':
+				log.Warn("could not pause", "err", client.Call(stubs.PauseHandler, empty, &stubs.Empty{}))
+			case 'k':
+				log.Warn("could not kill the server", "err", client.Call(stubs.KillServerHandler, empty, &stubs.Empty{}))
+			}
+		}
+	}
+	close(done)
+	<-flipsStopped
+
+	board, turn := fetchBoard(client, log)
+	c.events <- FinalTurnComplete{turn, board.AliveCells()}
+
+	// Make sure that the Io has finished any output before exiting.
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
+
+// fetchBoard gets a snapshot of the world from the server.
+func fetchBoard(client transport.Client, log *logging.Logger) (*tiles.World, int) {
+	getTiles := &stubs.GetTilesResponse{}
+	err := client.Call(stubs.GetTilesHandler, stubs.Empty{}, getTiles)
+	if err != nil {
+		log.Fatal("call failed", "method", stubs.GetTilesHandler, "err", err)
+	}
+	board := tiles.FromList(getTiles.Width, getTiles.Height, getTiles.Tiles)
+	board.Unbounded = getTiles.Unbounded
+	return board, getTiles.Turns
+}
@@ -0,0 +1,104 @@
+package gol
+
+import (
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// runPeers runs the board on a ring of workers without a broker. The workers elect a leader among themselves,
+// which the run is started on and asked for alive counts. Only the final board comes back, so the window jumps
+// straight to it, and key presses are not supported.
+func runPeers(p Params, c distributorChannels, board *tiles.World, session string, log *logging.Logger) {
+	if p.Unbounded {
+		log.Fatal("peers can only run boards that wrap around")
+	}
+
+	// Any worker in the ring can say which one leads
+	leader := ""
+	for _, address := range p.Peers {
+		client, err := transport.Dial(p.Transport, address)
+		if err != nil {
+			log.Warn("could not connect to peer", "peer", address, "err", err)
+			continue
+		}
+		elected := &stubs.ElectResponse{}
+		err = client.Call(stubs.ElectHandler, stubs.ElectRequest{Ring: p.Peers, Transport: p.Transport}, elected)
+		client.Close()
+		if err == nil {
+			leader = elected.Leader
+			break
+		}
+		log.Warn("call failed", "method", stubs.ElectHandler, "peer", address, "err", err)
+	}
+	if leader == "" {
+		log.Fatal("no peer could elect a leader", "peers", strings.Join(p.Peers, ","))
+	}
+	client, err := transport.Dial(p.Transport, leader)
+	if err != nil {
+		log.Fatal("could not connect to the leader", "leader", leader, "err", err)
+	}
+	defer client.Close()
+	log.Info("starting run on peers", "leader", leader, "peers", len(p.Peers), "turns", p.Turns)
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				status := &stubs.PeerStatusResponse{}
+				err := client.Call(stubs.PeerStatusHandler, stubs.Empty{}, status)
+				if err != nil {
+					log.Warn("call failed", "method", stubs.PeerStatusHandler, "err", err)
+					continue
+				}
+				c.events <- AliveCellsCount{status.Turn, status.Alive}
+			case <-c.keyPresses:
+				log.Warn("key presses are not supported when running on peers")
+			}
+		}
+	}()
+
+	request := stubs.PeerRunRequest{
+		Ring:      p.Peers,
+		Tiles:     board.List(),
+		Width:     p.ImageWidth,
+		Height:    p.ImageHeight,
+		Turns:     p.Turns,
+		Session:   session,
+		Transport: p.Transport,
+	}
+	response := &stubs.PeerRunResponse{}
+	err = client.Call(stubs.RunPeersHandler, request, response)
+	close(done)
+	<-stopped
+	if err != nil {
+		log.Fatal("call failed", "method", stubs.RunPeersHandler, "err", err)
+	}
+
+	final := tiles.FromList(p.ImageWidth, p.ImageHeight, response.Tiles)
+	turn := response.Turn
+	for _, cell := range board.Flipped(final) {
+		c.events <- CellFlipped{turn, cell}
+	}
+	c.events <- TurnComplete{turn}
+	c.events <- FinalTurnComplete{turn, final.AliveCells()}
+	savePGMImage(c, final, turn, p)
+
+	// Make sure that the Io has finished any output before exiting.
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
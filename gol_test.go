@@ -46,6 +46,30 @@ func TestGol(t *testing.T) {
 	}
 }
 
+// runGolden runs p and checks the final board against the check image for its size and turns, returning any
+// divergences the workers reported on the way.
+func runGolden(t *testing.T, p gol.Params) []gol.DivergenceDetected {
+	expectedAlive := readAliveCells(
+		"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns),
+		p.ImageWidth,
+		p.ImageHeight,
+	)
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	var divergences []gol.DivergenceDetected
+	for event := range events {
+		switch e := event.(type) {
+		case gol.DivergenceDetected:
+			divergences = append(divergences, e)
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	assertEqualBoard(t, cells, expectedAlive, p)
+	return divergences
+}
+
 func boardFail(t *testing.T, given, expected []util.Cell, p gol.Params) bool {
 	errorString := fmt.Sprintf("-----------------\n\n  FAILED TEST\n  %vx%v\n  %d Workers\n  %d Turns\n", p.ImageWidth, p.ImageHeight, p.Threads, p.Turns)
 	if p.ImageWidth == 16 && p.ImageHeight == 16 {
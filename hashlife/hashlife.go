@@ -0,0 +1,375 @@
+package hashlife
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxNodes is how many distinct nodes are kept before the memo tables are rebuilt from the current root.
+const maxNodes = 1 << 22
+
+// node is a square of 2^level cells split into four quadrants. Nodes are shared, two nodes with the same
+// quadrants are always the same node, so the result of evolving one only has to be worked out once.
+type node struct {
+	level          uint
+	nw, ne, sw, se *node
+	population     int
+	// result is the centre half of the node after 2^(level-2) generations
+	result *node
+}
+
+type key struct {
+	nw, ne, sw, se *node
+}
+
+type stepKey struct {
+	n    *node
+	step uint
+}
+
+// Universe runs the Game of Life as a memoised quadtree, so it can jump ahead 2^k generations at once.
+// On a wrapping board the root is the whole board, on an unbounded one X and Y are where its top left cell is.
+type Universe struct {
+	root          *node
+	x, y          int
+	unbounded     bool
+	width, height int
+
+	alive, dead *node
+	nodes       map[key]*node
+	steps       map[stepKey]*node
+	empty       []*node
+}
+
+// New loads a world into a universe. Wrapping boards have to be square with a power of two side.
+func New(world *tiles.World) (*Universe, error) {
+	u := &Universe{
+		unbounded: world.Unbounded,
+		width:     world.Width,
+		height:    world.Height,
+		alive:     &node{population: 1},
+		dead:      &node{},
+	}
+	u.reset()
+
+	cells := world.AliveCells()
+	if !u.unbounded {
+		level := uint(0)
+		for 1<<level < world.Width {
+			level++
+		}
+		if world.Width != world.Height || 1<<level != world.Width || level < 2 {
+			return nil, fmt.Errorf("hashlife needs a square board with a power of two side of at least 4, got %dx%d", world.Width, world.Height)
+		}
+		u.root = u.build(level, 0, 0, cells)
+		return u, nil
+	}
+
+	bounds := world.Bounds()
+	level := uint(3)
+	for 1<<level < bounds.Width || 1<<level < bounds.Height {
+		level++
+	}
+	u.x, u.y = bounds.X, bounds.Y
+	u.root = u.build(level, u.x, u.y, cells)
+	return u, nil
+}
+
+// Population is the number of alive cells.
+func (u *Universe) Population() int {
+	return u.root.population
+}
+
+// Step moves the universe forward by the largest power of two generations that is at most turns,
+// and that a wrapping board can do in one go, returning how many generations were done.
+func (u *Universe) Step(turns int) int {
+	if turns < 1 {
+		return 0
+	}
+	step := uint(0)
+	for step < 62 && 1<<(step+1) <= turns {
+		step++
+	}
+	if len(u.nodes) > maxNodes {
+		u.collect()
+	}
+
+	if !u.unbounded {
+		// A wrapping board is the same as that board repeated across the plane, so the centre of four copies
+		// is the board moved on and shifted by half its size, which swapping its quadrants undoes.
+		if step > u.root.level-1 {
+			step = u.root.level - 1
+		}
+		r := u.next(u.join(u.root, u.root, u.root, u.root), step)
+		u.root = u.join(r.se, r.sw, r.ne, r.nw)
+		return 1 << step
+	}
+
+	// Grow the root until the cells sit in its centre, then once more so nothing can reach its edge in time.
+	for u.root.level < step+2 || !u.centred() {
+		u.expand()
+	}
+	u.expand()
+	level := u.root.level
+	u.root = u.next(u.root, step)
+	u.x += 1 << (level - 2)
+	u.y += 1 << (level - 2)
+	u.shrink()
+	return 1 << step
+}
+
+// World converts the universe back into tiles.
+func (u *Universe) World() *tiles.World {
+	return u.Snapshot().World()
+}
+
+// Snapshot is the universe as it is at one point. Nodes are never changed once made, so a snapshot can be
+// converted into tiles later, without stopping the universe it came from moving on.
+type Snapshot struct {
+	root          *node
+	x, y          int
+	unbounded     bool
+	width, height int
+}
+
+// Snapshot takes the universe as it is now, which is cheap as no cells are copied.
+func (u *Universe) Snapshot() Snapshot {
+	return Snapshot{u.root, u.x, u.y, u.unbounded, u.width, u.height}
+}
+
+// World converts the snapshot into tiles.
+func (s Snapshot) World() *tiles.World {
+	world := tiles.New(s.width, s.height)
+	world.Unbounded = s.unbounded
+	walk(s.root, s.x, s.y, world)
+	return world
+}
+
+func walk(n *node, x, y int, world *tiles.World) {
+	if n.population == 0 {
+		return
+	}
+	if n.level == 0 {
+		world.Set(x, y, 255)
+		return
+	}
+	half := 1 << (n.level - 1)
+	walk(n.nw, x, y, world)
+	walk(n.ne, x+half, y, world)
+	walk(n.sw, x, y+half, world)
+	walk(n.se, x+half, y+half, world)
+}
+
+// build makes a node of the given level with its top left cell at x, y out of the alive cells inside it.
+func (u *Universe) build(level uint, x, y int, cells []util.Cell) *node {
+	if len(cells) == 0 {
+		return u.emptyNode(level)
+	}
+	if level == 0 {
+		return u.alive
+	}
+	half := 1 << (level - 1)
+	var nw, ne, sw, se []util.Cell
+	for _, c := range cells {
+		switch {
+		case c.Y < y+half && c.X < x+half:
+			nw = append(nw, c)
+		case c.Y < y+half:
+			ne = append(ne, c)
+		case c.X < x+half:
+			sw = append(sw, c)
+		default:
+			se = append(se, c)
+		}
+	}
+	return u.join(
+		u.build(level-1, x, y, nw),
+		u.build(level-1, x+half, y, ne),
+		u.build(level-1, x, y+half, sw),
+		u.build(level-1, x+half, y+half, se),
+	)
+}
+
+// join returns the one node made of the given quadrants.
+func (u *Universe) join(nw, ne, sw, se *node) *node {
+	k := key{nw, ne, sw, se}
+	if n, ok := u.nodes[k]; ok {
+		return n
+	}
+	n := &node{
+		level:      nw.level + 1,
+		nw:         nw,
+		ne:         ne,
+		sw:         sw,
+		se:         se,
+		population: nw.population + ne.population + sw.population + se.population,
+	}
+	u.nodes[k] = n
+	return n
+}
+
+func (u *Universe) emptyNode(level uint) *node {
+	for uint(len(u.empty)) <= level {
+		if len(u.empty) == 0 {
+			u.empty = append(u.empty, u.dead)
+			continue
+		}
+		e := u.empty[len(u.empty)-1]
+		u.empty = append(u.empty, u.join(e, e, e, e))
+	}
+	return u.empty[level]
+}
+
+// centred reports whether every alive cell is inside the centre half of the root.
+func (u *Universe) centred() bool {
+	r := u.root
+	return r.population == r.nw.se.population+r.ne.sw.population+r.sw.ne.population+r.se.nw.population
+}
+
+// expand doubles the size of the root, keeping the old root in the middle.
+func (u *Universe) expand() {
+	r := u.root
+	e := u.emptyNode(r.level - 1)
+	u.root = u.join(
+		u.join(e, e, e, r.nw),
+		u.join(e, e, r.ne, e),
+		u.join(e, r.sw, e, e),
+		u.join(r.se, e, e, e),
+	)
+	u.x -= 1 << (r.level - 1)
+	u.y -= 1 << (r.level - 1)
+}
+
+// shrink drops empty borders from the root so it does not keep growing with every step.
+func (u *Universe) shrink() {
+	for u.root.level > 3 && u.centred() {
+		level := u.root.level
+		u.root = u.centre(u.root)
+		u.x += 1 << (level - 2)
+		u.y += 1 << (level - 2)
+	}
+}
+
+// next returns the centre half of n after 2^step generations, step can be at most n.level-2.
+func (u *Universe) next(n *node, step uint) *node {
+	if n.population == 0 {
+		return u.emptyNode(n.level - 1)
+	}
+	if step == n.level-2 {
+		return u.result(n)
+	}
+	k := stepKey{n, step}
+	if r, ok := u.steps[k]; ok {
+		return r
+	}
+
+	// Nine overlapping sub squares are moved to their centres without any generations passing,
+	// then the four squares around the middle go forward by the whole step.
+	n00, n01, n02, n10, n11, n12, n20, n21, n22 := u.subsquares(n)
+	c00, c01, c02 := u.centre(n00), u.centre(n01), u.centre(n02)
+	c10, c11, c12 := u.centre(n10), u.centre(n11), u.centre(n12)
+	c20, c21, c22 := u.centre(n20), u.centre(n21), u.centre(n22)
+	r := u.join(
+		u.next(u.join(c00, c01, c10, c11), step),
+		u.next(u.join(c01, c02, c11, c12), step),
+		u.next(u.join(c10, c11, c20, c21), step),
+		u.next(u.join(c11, c12, c21, c22), step),
+	)
+	u.steps[k] = r
+	return r
+}
+
+// result returns the centre half of n after 2^(n.level-2) generations.
+func (u *Universe) result(n *node) *node {
+	if n.result != nil {
+		return n.result
+	}
+	if n.level == 2 {
+		n.result = u.base(n)
+		return n.result
+	}
+
+	// Each of the nine sub squares goes forward half the time, then the four squares they make up go forward the rest.
+	n00, n01, n02, n10, n11, n12, n20, n21, n22 := u.subsquares(n)
+	r00, r01, r02 := u.result(n00), u.result(n01), u.result(n02)
+	r10, r11, r12 := u.result(n10), u.result(n11), u.result(n12)
+	r20, r21, r22 := u.result(n20), u.result(n21), u.result(n22)
+	n.result = u.join(
+		u.result(u.join(r00, r01, r10, r11)),
+		u.result(u.join(r01, r02, r11, r12)),
+		u.result(u.join(r10, r11, r20, r21)),
+		u.result(u.join(r11, r12, r21, r22)),
+	)
+	return n.result
+}
+
+// subsquares splits n into nine overlapping squares of half its size.
+func (u *Universe) subsquares(n *node) (n00, n01, n02, n10, n11, n12, n20, n21, n22 *node) {
+	n00 = n.nw
+	n01 = u.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw)
+	n02 = n.ne
+	n10 = u.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne)
+	n11 = u.centre(n)
+	n12 = u.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne)
+	n20 = n.sw
+	n21 = u.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw)
+	n22 = n.se
+	return
+}
+
+// centre is the middle half of n.
+func (u *Universe) centre(n *node) *node {
+	return u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// base works out the middle 2x2 cells of a 4x4 node after one generation.
+func (u *Universe) base(n *node) *node {
+	var cells [4][4]int
+	quadrants := [4]*node{n.nw, n.ne, n.sw, n.se}
+	for q, quadrant := range quadrants {
+		leaves := [4]*node{quadrant.nw, quadrant.ne, quadrant.sw, quadrant.se}
+		for l, leaf := range leaves {
+			cells[(q/2)*2+l/2][(q%2)*2+l%2] = leaf.population
+		}
+	}
+	var next [4]*node
+	for i, c := range [4][2]int{{1, 1}, {1, 2}, {2, 1}, {2, 2}} {
+		y, x := c[0], c[1]
+		sum := cells[y-1][x-1] + cells[y-1][x] + cells[y-1][x+1] +
+			cells[y][x-1] + cells[y][x+1] +
+			cells[y+1][x-1] + cells[y+1][x] + cells[y+1][x+1]
+		if sum == 3 || (sum == 2 && cells[y][x] == 1) {
+			next[i] = u.alive
+		} else {
+			next[i] = u.dead
+		}
+	}
+	return u.join(next[0], next[1], next[2], next[3])
+}
+
+// collect throws away every memoised node that is not part of the current root.
+func (u *Universe) collect() {
+	old := u.root
+	u.reset()
+	u.root = u.copy(old, make(map[*node]*node))
+}
+
+func (u *Universe) copy(n *node, seen map[*node]*node) *node {
+	if n.level == 0 {
+		return n
+	}
+	if c, ok := seen[n]; ok {
+		return c
+	}
+	c := u.join(u.copy(n.nw, seen), u.copy(n.ne, seen), u.copy(n.sw, seen), u.copy(n.se, seen))
+	seen[n] = c
+	return c
+}
+
+func (u *Universe) reset() {
+	u.nodes = make(map[key]*node)
+	u.steps = make(map[stepKey]*node)
+	u.empty = nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestHashlife tests 16x16, 64x64 and 512x512 images on 0, 1 and 100 turns using the hashlife engine.
+func TestHashlife(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Threads = 1
+			p.Engine = "hashlife"
+			testName := fmt.Sprintf("%dx%dx%d-%s", p.ImageWidth, p.ImageHeight, p.Turns, p.Engine)
+			t.Run(testName, func(t *testing.T) {
+				runGolden(t, p)
+			})
+		}
+	}
+}
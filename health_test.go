@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestListWorkers runs 512x512 for 10 turns and checks every worker in the broker's workers.txt is listed as
+// healthy, has answered a heartbeat and was given rows on the last turn.
+func TestListWorkers(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 10, Threads: 4}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range events {
+	}
+
+	client, err := transport.Dial(transport.Gob, "127.0.0.1:8030")
+	util.Check(err)
+	defer client.Close()
+	listed := make(map[string]stubs.WorkerStatus)
+	// Heartbeats go out every second, so every worker should have answered one by then
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		res := stubs.ListWorkersResponse{}
+		util.Check(client.Call(stubs.ListWorkersHandler, stubs.Empty{}, &res))
+		heard := true
+		for _, w := range res.Workers {
+			listed[w.Address] = w
+			heard = heard && w.Latency > 0
+		}
+		if heard {
+			break
+		}
+	}
+
+	workers, err := ioutil.ReadFile("engine/workers.txt")
+	util.Check(err)
+	rows := 0
+	for _, address := range strings.Fields(string(workers)) {
+		w, ok := listed[address]
+		if !ok {
+			t.Errorf("expected %s to be listed, got %+v", address, listed)
+			continue
+		}
+		t.Logf("%s %s, heartbeat took %v, rows %d-%d", w.Address, w.Status, w.Latency, w.Strip.StartRow, w.Strip.EndRow)
+		if w.Status != stubs.WorkerHealthy || !w.Connected || w.Latency <= 0 || time.Since(w.LastSeen) > 5*time.Second {
+			t.Errorf("expected %s to be connected and answering heartbeats, got %+v", address, w)
+		}
+		rows += w.Strip.EndRow - w.Strip.StartRow
+	}
+	if rows != 512/64 {
+		t.Errorf("expected the workers to have been given %d tile rows between them, got %d", 512/64, rows)
+	}
+}
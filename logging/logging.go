@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how serious a line is. Lines below the level set with -log-level are dropped.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// Set parses a level name so a Level can be used as a flag.
+func (l *Level) Set(name string) error {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+var mu sync.Mutex
+var out io.Writer = os.Stderr
+var minimum = InfoLevel
+var node string
+
+// LevelFlag adds the -log-level flag every binary takes.
+func LevelFlag() {
+	flag.Var(&minimum, "log-level", "Lowest level logged: debug, info, warn or error")
+}
+
+// SetNode names this process in every line, so lines from a whole cluster can be put together and still told apart.
+func SetNode(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	node = name
+}
+
+// SetOutput sends lines somewhere other than stderr.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// NewSession makes an ID for a run, which the controller passes on so every node logs it.
+func NewSession() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger writes lines in logfmt, key=value pairs after the time, level and message, always with its own fields.
+type Logger struct {
+	fields []interface{}
+}
+
+// With returns a logger that adds the given key value pairs to every line.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := append([]interface{}(nil), l.fields...)
+	return &Logger{append(fields, keyValues...)}
+}
+
+func (l *Logger) Debug(msg string, keyValues ...interface{}) { l.log(DebugLevel, msg, keyValues) }
+func (l *Logger) Info(msg string, keyValues ...interface{})  { l.log(InfoLevel, msg, keyValues) }
+func (l *Logger) Warn(msg string, keyValues ...interface{})  { l.log(WarnLevel, msg, keyValues) }
+func (l *Logger) Error(msg string, keyValues ...interface{}) { l.log(ErrorLevel, msg, keyValues) }
+
+// Fatal logs at error level and exits.
+func (l *Logger) Fatal(msg string, keyValues ...interface{}) {
+	l.log(ErrorLevel, msg, keyValues)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyValues []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if level < minimum {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	if node != "" {
+		b.WriteString(" node=")
+		b.WriteString(quote(node))
+	}
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	writePairs(&b, l.fields)
+	writePairs(&b, keyValues)
+	b.WriteString("\n")
+	io.WriteString(out, b.String())
+}
+
+func writePairs(b *strings.Builder, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(keyValues[i]))
+		b.WriteString("=")
+		if i+1 < len(keyValues) {
+			b.WriteString(quote(fmt.Sprint(keyValues[i+1])))
+		} else {
+			b.WriteString("MISSING")
+		}
+	}
+}
+
+// quote leaves simple values bare and quotes anything that would split the line up wrongly.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// std is the logger with no fields of its own, used by the functions below.
+var std = &Logger{}
+
+// With returns a logger that adds the given key value pairs to every line.
+func With(keyValues ...interface{}) *Logger { return std.With(keyValues...) }
+
+func Debug(msg string, keyValues ...interface{}) { std.log(DebugLevel, msg, keyValues) }
+func Info(msg string, keyValues ...interface{})  { std.log(InfoLevel, msg, keyValues) }
+func Warn(msg string, keyValues ...interface{})  { std.log(WarnLevel, msg, keyValues) }
+func Error(msg string, keyValues ...interface{}) { std.log(ErrorLevel, msg, keyValues) }
+func Fatal(msg string, keyValues ...interface{}) { std.Fatal(msg, keyValues...) }
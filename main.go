@@ -2,11 +2,13 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"runtime"
+	"strings"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/logging"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/transport"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -38,16 +40,91 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.BoolVar(
+		&params.Unbounded,
+		"unbounded",
+		false,
+		"Run on an infinite plane instead of wrapping around the edges of the image. Workers are handed rectangles around the live area each turn rather than owning tiles. Defaults to false.")
+
+	flag.StringVar(
+		&params.Engine,
+		"engine",
+		"workers",
+		"Specify how turns are computed, either workers or hashlife. Defaults to workers.")
+
+	flag.StringVar(
+		&params.OnCycle,
+		"onCycle",
+		"continue",
+		"Specify what to do once the world repeats itself: continue, stop or skip to the last turn. Defaults to continue.")
+
+	flag.StringVar(
+		&params.Transport,
+		"transport",
+		"gob",
+		"Specify the protocol used to talk to the broker, either gob or json. Defaults to gob.")
+
+	flag.StringVar(
+		&params.Decomposition,
+		"decomposition",
+		"strips",
+		"Specify how the board is split between the workers, strips of rows or blocks. Defaults to strips.")
+
+	flag.IntVar(
+		&params.TurnsPerCall,
+		"turnsPerCall",
+		1,
+		"Specify how many turns workers work out each time they are called, up to 64. More means fewer round trips but more work repeated around the edges. Defaults to 1.")
+
+	flag.BoolVar(
+		&params.Verify,
+		"verify",
+		false,
+		"Specify whether every strip is worked out by two workers and the answers compared, to catch a worker giving wrong results. Defaults to false.")
+
+	flag.StringVar(
+		&params.Server,
+		"server",
+		"127.0.0.1:8030",
+		"Specify the address of the broker. Defaults to 127.0.0.1:8030.")
+
+	flag.StringVar(
+		&params.Standby,
+		"standby",
+		"",
+		"Specify the address of a standby broker to carry the run on if the broker stops answering. Defaults to none.")
+
+	peers := flag.String(
+		"peers",
+		"",
+		"Specify a comma separated ring of workers to run on without a broker. Defaults to using the broker.")
+
+	flag.StringVar(
+		&params.TraceFile,
+		"traceFile",
+		"",
+		"Write a Chrome trace of every turn across the broker and workers to this file. Defaults to no trace.")
+
+	secure := transport.SecurityFlags()
+	logging.LevelFlag()
+
 	noVis := flag.Bool(
 		"noVis",
 		false,
 		"Disables the SDL window, so there is no visualisation during the tests.")
 
 	flag.Parse()
+	logging.SetNode("controller")
+	if *peers != "" {
+		params.Peers = strings.Split(*peers, ",")
+	}
+
+	err := transport.Configure(*secure)
+	if err != nil {
+		logging.Fatal("could not set up security", "err", err)
+	}
 
-	fmt.Println("Threads:", params.Threads)
-	fmt.Println("Width:", params.ImageWidth)
-	fmt.Println("Height:", params.ImageHeight)
+	logging.Info("starting", "threads", params.Threads, "width", params.ImageWidth, "height", params.ImageHeight)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets are the upper bounds histograms count into, in seconds. They go from half a millisecond,
+// a call to a worker on the same machine, up to the ten seconds a turn of a huge world can take.
+var Buckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is one name with a value for each set of label values it has been given.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the value of a metric for one set of label values. Histograms use buckets, sum and count.
+type series struct {
+	values  []string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+var registry = make(map[string]*metric)
+var registryMu sync.Mutex
+
+func register(name string, help string, kind string, labels []string) *metric {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("metric " + name + " registered twice")
+	}
+	m := &metric{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+	registry[name] = m
+	return m
+}
+
+// get returns the series for the label values, making it the first time they are seen.
+// The caller has to hold m.mu.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, given %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(Buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a total that only goes up, like bytes sent.
+type Counter struct{ m *metric }
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, "counter", labels)}
+}
+
+func (c *Counter) Add(value float64, labels ...string) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(labels).value += value
+}
+
+// Gauge is a value that can go either way, like the current turn.
+type Gauge struct{ m *metric }
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labels)}
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labels).value = value
+}
+
+// Histogram counts how many observations fell under each of Buckets, for latencies.
+type Histogram struct{ m *metric }
+
+func NewHistogram(name string, help string, labels ...string) *Histogram {
+	return &Histogram{register(name, help, "histogram", labels)}
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(labels)
+	for i, bound := range Buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Write prints every metric in the Prometheus text format, sorted so scrapes are easy to compare by eye.
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		registryMu.Lock()
+		m := registry[name]
+		registryMu.Unlock()
+		err := m.write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metric) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", m.name, m.labelString(s.values, "", ""), formatValue(s.value))
+			continue
+		}
+		for i, bound := range Buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, m.labelString(s.values, "le", formatValue(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, m.labelString(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, m.labelString(s.values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", m.name, m.labelString(s.values, "", ""), s.count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// labelString renders {name="value",...}, with one extra label on the end when extra is set.
+func (m *metric) labelString(values []string, extra string, extraValue string) string {
+	var pairs []string
+	for i, label := range m.labels {
+		pairs = append(pairs, label+"=\""+escapeLabel(values[i])+"\"")
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+"=\""+extraValue+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+var helpEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestMetrics runs 512x512 for 10 turns then scrapes the broker and the first worker.
+func TestMetrics(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 10, Threads: 4}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range events {
+	}
+
+	scrapes := map[string][]string{
+		"127.0.0.1:8030": {
+			"gol_turn 10\n",
+			"gol_evolving 0\n",
+			"gol_alive_cells ",
+			"gol_turns_per_second ",
+			"gol_worker_up{worker=\":8040\"} 1\n",
+			"gol_rpc_seconds_count{method=\"WorldOps.CalculateWorld\",peer=\":8040\"} ",
+			"gol_sent_bytes_total{peer=\":8040\"} ",
+			"gol_received_bytes_total{peer=\"incoming\"} ",
+		},
+		"127.0.0.1:8040": {
+			"# TYPE gol_calculate_seconds histogram\n",
+			"gol_calculate_seconds_bucket{le=\"+Inf\"} ",
+			"gol_tiles_calculated_total ",
+			"gol_sent_bytes_total{peer=\"incoming\"} ",
+		},
+	}
+	for address, wanted := range scrapes {
+		response, err := http.Get("http://" + address + "/metrics")
+		util.Check(err)
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		util.Check(err)
+		for _, line := range wanted {
+			if !strings.Contains(string(body), line) {
+				t.Errorf("%s/metrics is missing %q", address, line)
+			}
+		}
+	}
+}
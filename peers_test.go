@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPeers runs the check images on the workers in engine/workers.txt without going through the broker. The
+// ring starts with an address nobody listens on, which the workers have to leave out when electing a leader.
+// Every image is run with the workers talking gob and then JSON-RPC.
+func TestPeers(t *testing.T) {
+	list, err := ioutil.ReadFile("engine/workers.txt")
+	util.Check(err)
+	ring := append([]string{"127.0.0.1:8099"}, strings.Fields(string(list))...)
+
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Threads = 4
+			p.Peers = ring
+			t.Run(fmt.Sprintf("%dx%dx%d-peers", p.ImageWidth, p.ImageHeight, p.Turns), func(t *testing.T) {
+				runGolden(t, p)
+			})
+			// The workers call each other over JSON-RPC as well when the controller does
+			p.Transport = "json"
+			t.Run(fmt.Sprintf("%dx%dx%d-peers-%s", p.ImageWidth, p.ImageHeight, p.Turns, p.Transport), func(t *testing.T) {
+				runGolden(t, p)
+			})
+			p.Transport = ""
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"testing"
 	"uk.ac.bris.cs/gameoflife/gol"
 )
@@ -40,3 +41,26 @@ func TestPgm(t *testing.T) {
 		}
 	}
 }
+
+// BenchmarkPgm measures loading and saving 16x16 to 512x512 images with no turns, which is dominated by the io
+// goroutine. The io goroutine on its own is measured by BenchmarkReadPgm and BenchmarkWritePgm in gol.
+func BenchmarkPgm(b *testing.B) {
+	os.Stdout = nil // Disable all program output apart from benchmark results
+	for _, size := range []int{16, 64, 128, 256, 512} {
+		p := gol.Params{
+			Turns:       0,
+			Threads:     1,
+			ImageWidth:  size,
+			ImageHeight: size,
+		}
+		name := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				events := make(chan gol.Event)
+				go gol.Run(p, events, nil)
+				for range events {
+				}
+			}
+		})
+	}
+}
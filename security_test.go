@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Echo is a stand in for the broker so the security checks can be tried without restarting the cluster.
+type Echo struct{}
+
+func (e *Echo) Say(req string, res *string) error {
+	*res = req
+	return nil
+}
+
+// TestSecurity serves with a certificate and token, then checks calls only get through with both.
+func TestSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol-certs")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	defer transport.Configure(transport.Security{})
+
+	ca, caKey := makeCert(t, dir, "ca", nil, nil)
+	makeCert(t, dir, "node", ca, caKey)
+	other, otherKey := makeCert(t, dir, "other-ca", nil, nil)
+	makeCert(t, dir, "intruder", other, otherKey)
+
+	secure := func(name string, token string) transport.Security {
+		return transport.Security{
+			CertFile: filepath.Join(dir, name+".pem"),
+			KeyFile:  filepath.Join(dir, name+".key"),
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			Token:    token,
+		}
+	}
+
+	util.Check(transport.Configure(secure("node", "secret")))
+	server := rpc.NewServer()
+	util.Check(server.Register(&Echo{}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	util.Check(err)
+	defer listener.Close()
+	go transport.Serve(listener, server)
+	address := listener.Addr().String()
+	waitForServer(address)
+
+	tests := []struct {
+		name     string
+		security transport.Security
+		refused  string
+	}{
+		{"certificate and token", secure("node", "secret"), ""},
+		{"wrong token", secure("node", "guess"), "invalid token"},
+		{"no token", secure("node", ""), "invalid token"},
+		{"untrusted certificate", secure("intruder", "secret"), "certificate"},
+		{"plain tcp", transport.Security{Token: "secret"}, "EOF"},
+	}
+	for _, test := range tests {
+		for _, protocol := range []string{transport.Gob, transport.JSON} {
+			t.Run(test.name+"-"+protocol, func(t *testing.T) {
+				util.Check(transport.Configure(test.security))
+				var reply string
+				client, err := transport.Dial(protocol, address)
+				if err == nil {
+					err = client.Call("Echo.Say", "hello", &reply)
+					client.Close()
+				}
+				if test.refused == "" {
+					if err != nil || reply != "hello" {
+						t.Fatalf("expected the call to get through, got %q, %v", reply, err)
+					}
+					return
+				}
+				if err == nil {
+					t.Fatalf("expected the call to be refused, got %q", reply)
+				}
+				if !strings.Contains(err.Error(), test.refused) {
+					t.Fatalf("expected the call to be refused with %q, got %v", test.refused, err)
+				}
+			})
+		}
+	}
+}
+
+// Panel stands in for the broker's handlers, one anyone can call and one only operators can.
+type Panel struct{}
+
+func (p *Panel) Role(req stubs.Empty, res *stubs.RoleResponse) error {
+	res.Role = req.Role
+	return req.Check(stubs.Observer, "look")
+}
+
+func (p *Panel) Pause(req stubs.Empty, res *stubs.Empty) error {
+	return req.Check(stubs.Operator, "pause runs")
+}
+
+// TestRoles checks the token a call is made with decides its role, whatever role the caller claims.
+func TestRoles(t *testing.T) {
+	defer transport.Configure(transport.Security{})
+	util.Check(transport.Configure(transport.Security{Token: "operate", ObserverToken: "watch"}))
+	server := rpc.NewServer()
+	util.Check(server.Register(&Panel{}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	util.Check(err)
+	defer listener.Close()
+	go transport.Serve(listener, server)
+	waitForServer(listener.Addr().String())
+
+	for _, protocol := range []string{transport.Gob, transport.JSON} {
+		for _, token := range []string{"operate", "watch"} {
+			t.Run(token+"-"+protocol, func(t *testing.T) {
+				util.Check(transport.Configure(transport.Security{Token: token}))
+				client, err := transport.Dial(protocol, listener.Addr().String())
+				util.Check(err)
+				defer client.Close()
+
+				// Claiming to be an operator should make no difference
+				claim := stubs.Empty{Caller: stubs.Caller{Role: stubs.Operator}}
+				role := &stubs.RoleResponse{}
+				util.Check(client.Call("Panel.Role", claim, role))
+				err = client.Call("Panel.Pause", claim, &stubs.Empty{})
+				if token == "operate" && (role.Role != stubs.Operator || err != nil) {
+					t.Fatalf("expected an operator that can pause, got %q, %v", role.Role, err)
+				}
+				if token == "watch" && (role.Role != stubs.Observer || err == nil || !strings.Contains(err.Error(), "observers cannot pause runs")) {
+					t.Fatalf("expected an observer that cannot pause, got %q, %v", role.Role, err)
+				}
+			})
+		}
+	}
+}
+
+// TestPageToken checks only a GET for a page added with HandlePage may give its token in the address, and that
+// every other endpoint still needs it in a header.
+func TestPageToken(t *testing.T) {
+	defer transport.Configure(transport.Security{})
+	util.Check(transport.Configure(transport.Security{Token: "secret"}))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	transport.Handle("/test/api", ok)
+	transport.HandlePage("/test/page", ok)
+	server := rpc.NewServer()
+	util.Check(server.Register(&Panel{}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	util.Check(err)
+	defer listener.Close()
+	go transport.Serve(listener, server)
+	waitForServer(listener.Addr().String())
+
+	tests := []struct {
+		method, path, header string
+		status               int
+	}{
+		{"GET", "/test/page?token=secret", "", http.StatusOK},
+		{"GET", "/test/page?token=guess", "", http.StatusUnauthorized},
+		{"POST", "/test/page?token=secret", "", http.StatusUnauthorized},
+		{"GET", "/test/api?token=secret", "", http.StatusUnauthorized},
+		{"POST", "/test/api", "secret", http.StatusOK},
+		{"GET", "/metrics?token=secret", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.method+test.path, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, "http://"+listener.Addr().String()+test.path, nil)
+			util.Check(err)
+			if test.header != "" {
+				req.Header.Set("X-Gol-Token", test.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != test.status {
+				t.Errorf("expected %d, got %d", test.status, res.StatusCode)
+			}
+		})
+	}
+}
+
+// waitForServer makes a call so the server has taken its copy of the settings before they are changed.
+func waitForServer(address string) {
+	client, err := transport.Dial(transport.Gob, address)
+	util.Check(err)
+	client.Call("Panel.Role", stubs.Empty{}, &stubs.RoleResponse{})
+	client.Close()
+}
+
+// makeCert writes name.pem and name.key into dir, signed by parent or self signed as a CA when parent is nil.
+func makeCert(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.Check(err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	util.Check(err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// Brokers call the workers with the certificate they serve with, so it is used both ways
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:    []string{"localhost"},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	util.Check(err)
+	cert, err := x509.ParseCertificate(der)
+	util.Check(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	util.Check(err)
+	util.Check(ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	util.Check(ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, key
+}
@@ -1,30 +1,128 @@
 package stubs
 
-import "uk.ac.bris.cs/gameoflife/util"
+import (
+	"fmt"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/tracing"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Version is the version of the wire protocol described by the types in this package.
+// It is checked whenever a connection is made, so bump it whenever any of them change or what their fields mean
+// does. gob leaves out fields the other end does not know, so mismatched builds would otherwise get wrong answers
+// rather than an error.
+const Version = 6
 
 var EvolveWorldHandler = "GOLWorker.EvolveWorld"
 var AliveCellsCountHandler = "GOLWorker.AliveCellsCount"
 var AliveCellsHandler = "GOLWorker.CalculateAliveCells"
 var GetGlobalHandler = "GOLWorker.GetGlobal"
+var GetTilesHandler = "GOLWorker.GetTiles"
+var BandwidthHandler = "GOLWorker.Bandwidth"
+var GetFlippedHandler = "GOLWorker.GetFlipped"
+var RoleHandler = "GOLWorker.Role"
+var GetTraceHandler = "GOLWorker.GetTrace"
+var PartitionHandler = "GOLWorker.GetPartition"
+var ListWorkersHandler = "GOLWorker.ListWorkers"
+var ReplicateHandler = "GOLWorker.Replicate"
+var ResumeHandler = "GOLWorker.Resume"
 var PauseHandler = "GOLWorker.Pause"
 var UnpauseHandler = "GOLWorker.Unpause"
 var QuitHandler = "GOLWorker.QuitServer"
 
 var KillServerHandler = "GOLWorker.KillServer"
 
+// Operators can start, pause, quit and kill runs. Observers can only look at them: alive counts,
+// snapshots and the cells flipped each turn.
+var Operator = "operator"
+var Observer = "observer"
+
+// Caller is filled in by the server with the role of whoever made the call, whatever the client sent in it.
+type Caller struct {
+	Role string
+}
+
+// SetRole is how the server fills in Caller.
+func (c *Caller) SetRole(role string) {
+	c.Role = role
+}
+
+// Check returns an error unless the caller has at least the given role. Operators can do anything observers can.
+func (c Caller) Check(role string, action string) error {
+	if c.Role == Operator || (role == Observer && c.Role == Observer) {
+		return nil
+	}
+	who := c.Role + "s"
+	if c.Role == "" {
+		who = "unknown callers"
+	}
+	return fmt.Errorf("permission denied: %s cannot %s", who, action)
+}
+
+// HashlifeEngine can be asked for in EvolveWorldRequest.Engine to run the board as a memoised quadtree
+// in the broker instead of splitting it between the workers.
+var HashlifeEngine = "hashlife"
+
+// StopOnCycle and SkipOnCycle can be asked for in EvolveWorldRequest.OnCycle. Once the world repeats itself
+// the run either ends there or jumps straight to the state it would be in after the last turn.
+var StopOnCycle = "stop"
+var SkipOnCycle = "skip"
+
+// StripsDecomposition and BlocksDecomposition can be asked for in EvolveWorldRequest.Decomposition. Strips are
+// whole tile rows, blocks split the board into a grid with one block per worker, which has less edge to send.
+var StripsDecomposition = "strips"
+var BlocksDecomposition = "blocks"
+
+// Period is how often the world repeats and PeriodTurn the turn that was noticed on, or 0 if it has not repeated.
 type EvolveResponse struct {
-	World [][]byte
-	Turn  int
+	Tiles      []*tiles.Tile
+	Turn       int
+	Period     int
+	PeriodTurn int
+	// Divergences are the strips workers disagreed on when results were verified
+	Divergences []Divergence
+}
+
+// Divergence is part of a turn two workers worked out differently. The rows and columns are in cells and cover
+// the tiles they disagreed on, Tiles is how many of those there were.
+type Divergence struct {
+	Turn     int
+	StartRow int
+	EndRow   int
+	StartCol int
+	EndCol   int
+	Workers  []string
+	Tiles    int
 }
 
+// EvolveWorldRequest only carries the tiles with alive cells in them, so sparse boards much larger than
+// could be held densely can be evolved.
 type EvolveWorldRequest struct {
-	World       [][]byte
+	Caller
+	Tiles       []*tiles.Tile
 	Width       int
 	Height      int
 	Turn        int
 	Threads     int
 	ImageHeight int
 	ImageWidth  int
+	Unbounded   bool
+	Engine      string
+	OnCycle     string
+	// CellEvents keeps the cells flipped each turn until they are fetched with GetFlipped
+	CellEvents bool
+	// Session names the run in the logs of every node
+	Session string
+	// Trace times every turn on the broker and workers, the spans are fetched with GetTrace
+	Trace bool
+	// Decomposition is how the board is split between the workers, strips when empty
+	Decomposition string
+	// TurnsPerCall is how many turns workers work out between hearing from the broker, at most a tile's width.
+	TurnsPerCall int
+	// Verify has every strip worked out by two workers and the answers compared
+	Verify bool
 }
 type CalculateAliveCellsRequest struct {
 	World [][]byte
@@ -32,12 +130,145 @@ type CalculateAliveCellsRequest struct {
 type CalculateAliveCellsResponse struct {
 	AliveCells []util.Cell
 }
+
+// Evolving is whether a run is going on, so observers know when the one they are following has finished.
 type AliveCellsCountResponse struct {
 	AliveCellsCount int
 	CompletedTurns  int
+	Period          int
+	PeriodTurn      int
+	Evolving        bool
 }
 type GetGlobalResponse struct {
 	World [][]byte
 	Turns int
 }
-type Empty struct{}
+
+// GetTilesResponse is a snapshot of the board. Bounds is the whole board, or the box around every
+// alive cell and its offset from the origin when the world is unbounded.
+type GetTilesResponse struct {
+	Tiles     []*tiles.Tile
+	Width     int
+	Height    int
+	Unbounded bool
+	Bounds    tiles.Bounds
+	Turns     int
+}
+
+// BandwidthResponse counts the tile bytes sent to and back from the workers. RawBytes is what they would
+// have taken unpacked and SentBytes what was actually sent, for the last turn and for the whole run.
+type BandwidthResponse struct {
+	Turn           int
+	RawBytes       int
+	SentBytes      int
+	TotalRawBytes  int
+	TotalSentBytes int
+	Encodings      []string
+}
+
+// TurnFlips is the cells flipped on one turn. When the broker jumped over turns, as hashlife and skipping
+// a cycle do, Resync is set and Tiles holds the whole world at that turn instead.
+type TurnFlips struct {
+	Turn    int
+	Flipped []util.Cell
+	Resync  bool
+	Tiles   []*tiles.Tile
+}
+
+// GetFlippedRequest asks for the turns after After. The controller that started the run is the Owner:
+// turns it has fetched are dropped and the run waits for it when it falls behind. Anyone else is handed
+// a resync when the turns they missed are gone. Session is the run the turns are wanted from, nothing is
+// handed over until it has started. Observers leave it empty to follow whichever run is going.
+// Divergences is how many of the run's divergences the caller has already been sent.
+type GetFlippedRequest struct {
+	Caller
+	After       int
+	Owner       bool
+	Session     string
+	Divergences int
+}
+
+// GetFlippedResponse holds every turn finished since the last call, and the divergences found since, which
+// are sent whether or not the run keeps flipped cells.
+type GetFlippedResponse struct {
+	Turns       []TurnFlips
+	Divergences []Divergence
+}
+
+// RoleResponse is the role the server gave the caller.
+type RoleResponse struct {
+	Role string
+}
+
+// WorkerStrip is the tile rows StartRow to EndRow and columns StartCol to EndCol one worker was given, holding
+// Active tiles that needed working out. Share is the fraction of the active tiles it was meant to get and
+// Latency how long its call took. Backup is the worker whose answer was used instead when the strip was slow or
+// failed and was sent to another worker as well.
+type WorkerStrip struct {
+	Worker   string
+	StartRow int
+	EndRow   int
+	StartCol int
+	EndCol   int
+	Active   int
+	Share    float64
+	Latency  time.Duration
+	Backup   string
+}
+
+// PartitionResponse is how the world was split between the workers on turn Turn.
+type PartitionResponse struct {
+	Turn   int
+	Strips []WorkerStrip
+}
+
+// ReplicateRequest asks a broker for the state of its run, leaving the world out if it is still on turn After
+// of the same session.
+type ReplicateRequest struct {
+	Caller
+	After   int
+	Session string
+}
+
+// ReplicateResponse is what a standby broker keeps so it can carry the run on if the primary stops answering.
+// Run is the request that started the run, without its tiles. Tiles is the world after Turn when HasWorld is
+// set, which it has to be as gob leaves out an empty list of tiles the same as one that was not sent.
+type ReplicateResponse struct {
+	Run         EvolveWorldRequest
+	Evolving    bool
+	Turn        int
+	HasWorld    bool
+	Tiles       []*tiles.Tile
+	Divergences []Divergence
+}
+
+// A worker is healthy while it answers heartbeats and calls, unhealthy once one fails or is not answered in time,
+// and unknown before it has been heard from.
+var WorkerHealthy = "healthy"
+var WorkerUnhealthy = "unhealthy"
+var WorkerUnknown = "unknown"
+
+// WorkerStatus is what the broker knows about one worker. LastSeen is when it last answered and Latency how long
+// its last heartbeat took. Error is why it was last marked unhealthy. Strip is what it was given on the last turn.
+type WorkerStatus struct {
+	Address   string
+	Status    string
+	Connected bool
+	LastSeen  time.Time
+	Latency   time.Duration
+	Error     string
+	Strip     WorkerStrip
+}
+
+// ListWorkersResponse lists every worker in workers.txt or connected to, in the order they were first heard of.
+type ListWorkersResponse struct {
+	Workers []WorkerStatus
+}
+
+// GetTraceResponse holds the spans recorded for the last traced run.
+type GetTraceResponse struct {
+	Spans []tracing.Span
+}
+type Empty struct {
+	Caller
+}
@@ -1,16 +1,159 @@
 package stubs
 
+import (
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/tracing"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 var WorldHandler = "WorldOps.CalculateWorld"
 var KillHandler = "WorldOps.KillWorker"
+var EncodingsHandler = "WorldOps.Encodings"
+var HeartbeatHandler = "WorldOps.Heartbeat"
+var ElectHandler = "WorldOps.Elect"
+var RunPeersHandler = "WorldOps.RunPeers"
+var PeerStatusHandler = "WorldOps.PeerStatus"
+var OwnHandler = "WorldOps.Own"
+var StepHandler = "WorldOps.Step"
+var EdgeHandler = "WorldOps.Edge"
+var CollectHandler = "WorldOps.Collect"
 
+// WorldReq asks a worker for the next state of tile rows StartRow to EndRow.
+// Tiles holds those rows plus the tile row either side of them. When Active is set only the tiles
+// listed in it can change, and Tiles only needs to hold them and their neighbours.
+// When Encoding is set the tiles are sent in Packed instead, and the worker packs its result the same way.
+// Deltas asks for tiles that only changed a little to come back as the cells that flipped.
 type WorldReq struct {
-	World    [][]byte
-	Width    int
-	Height   int
-	StartRow int
-	EndRow   int
+	Caller
+	Tiles     []*tiles.Tile
+	Packed    []byte
+	Encoding  string
+	Active    []tiles.Coord
+	Width     int
+	Height    int
+	Unbounded bool
+	StartRow  int
+	EndRow    int
+	// StartCol and EndCol narrow the rows down to a block, every column is worked out when they are equal
+	StartCol int
+	EndCol   int
+	Deltas   bool
+	// Session and Turn are only for logging
+	Session string
+	Turn    int
+	// Trace is the ID of the turn when it is being traced, the worker then sends back how long each step took
+	Trace string
+	// Turns is how many turns to work out before answering, one when 0. For more than one the halo is Turns
+	// cells deep: Active also lists the active tiles next to the region, and the tiles next to the region in
+	// Tiles only hold their cells within Turns of it. The halo is worked out too but not sent back.
+	Turns int
 }
 
+// WorldRes holds the tiles that changed this turn. Changed lists every one of them, including
+// those that are no longer sent because they have no alive cells left. Changed tiles are either sent
+// whole or as the cells in them that flipped.
 type WorldRes struct {
-	World [][]byte
+	Tiles   []*tiles.Tile
+	Packed  []byte
+	Flipped []util.Cell
+	Changed []tiles.Coord
+	// Spans are timed on the worker's clock, the first one covering the rest
+	Spans []tracing.Span
+	// LastChanged lists the tiles that changed on the last of several turns
+	LastChanged []tiles.Coord
+}
+
+// EncodingsResponse lists the tile encodings a worker can unpack, best first.
+type EncodingsResponse struct {
+	Encodings []string
+}
+
+// ElectRequest asks a worker which of a ring of workers leads a run without a broker. The leader is the first
+// one in the ring that answers a heartbeat, so every worker picks the same one. Transport is the protocol the
+// workers call each other with, gob when empty.
+type ElectRequest struct {
+	Caller
+	Ring      []string
+	Transport string
+}
+
+type ElectResponse struct {
+	Leader string
+}
+
+// PeerRunRequest asks the leader of a ring to run the world for Turns turns. The leader splits the rows between
+// the workers in the ring that answer, which swap the tile rows along their edges with each other every turn.
+// Transport is the protocol the workers call each other with, gob when empty.
+type PeerRunRequest struct {
+	Caller
+	Ring      []string
+	Tiles     []*tiles.Tile
+	Width     int
+	Height    int
+	Turns     int
+	Session   string
+	Transport string
+}
+
+type PeerRunResponse struct {
+	Tiles []*tiles.Tile
+	Turn  int
+}
+
+// PeerStatusResponse is how far the run the leader is coordinating has got.
+type PeerStatusResponse struct {
+	Turn     int
+	Alive    int
+	Evolving bool
+}
+
+// OwnRequest hands a worker tile rows StartRow to EndRow of the world after Turn. Above and Below are the workers
+// with the rows either side, which are asked for their edges each turn over Transport, gob when empty.
+type OwnRequest struct {
+	Caller
+	Session   string
+	Tiles     []*tiles.Tile
+	Width     int
+	Height    int
+	StartRow  int
+	EndRow    int
+	Turn      int
+	Above     string
+	Below     string
+	Transport string
+}
+
+// StepRequest asks a worker to work out turn Turn of its rows.
+type StepRequest struct {
+	Caller
+	Session string
+	Turn    int
+}
+
+// StepResponse is how many cells are alive in the worker's rows after the turn.
+type StepResponse struct {
+	Alive int
+}
+
+// EdgeRequest asks a worker for its first tile row, or last when Top is false, as it was after Turn.
+type EdgeRequest struct {
+	Caller
+	Session string
+	Turn    int
+	Top     bool
+}
+
+type EdgeResponse struct {
+	Tiles []*tiles.Tile
+}
+
+// CollectRequest asks a worker for the rows it owns.
+type CollectRequest struct {
+	Caller
+	Session string
+}
+
+type CollectResponse struct {
+	Tiles []*tiles.Tile
+	Turn  int
 }
@@ -0,0 +1,137 @@
+package tiles
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// RLE packs tiles as runs of equal cells, which suits boards made of long stretches of 0s and 255s.
+// Flate runs the RLE output through DEFLATE as well to squeeze out repeated patterns of runs.
+const (
+	RLE   = "rle"
+	Flate = "flate"
+)
+
+// Encodings lists every encoding Pack understands, best first.
+var Encodings = []string{Flate, RLE}
+
+// TileBytes is roughly how many bytes one tile takes up unpacked, its cells and position.
+const TileBytes = Size*Size + 16
+
+// RawSize is how many bytes a list of tiles takes up unpacked.
+func RawSize(list []*Tile) int {
+	return len(list) * TileBytes
+}
+
+// Pack encodes a list of tiles with the given encoding.
+func Pack(list []*Tile, encoding string) ([]byte, error) {
+	var packed bytes.Buffer
+	switch encoding {
+	case RLE:
+		writeRuns(&packed, list)
+	case Flate:
+		writer, err := flate.NewWriter(&packed, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		runs := bufio.NewWriter(writer)
+		writeRuns(runs, list)
+		err = runs.Flush()
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown tile encoding %q", encoding)
+	}
+	return packed.Bytes(), nil
+}
+
+// Unpack decodes tiles packed by Pack.
+func Unpack(packed []byte, encoding string) ([]*Tile, error) {
+	switch encoding {
+	case RLE:
+		return readRuns(bufio.NewReader(bytes.NewReader(packed)))
+	case Flate:
+		reader := flate.NewReader(bytes.NewReader(packed))
+		defer reader.Close()
+		return readRuns(bufio.NewReader(reader))
+	}
+	return nil, fmt.Errorf("unknown tile encoding %q", encoding)
+}
+
+// writeRuns writes the tile count, then each tile's position followed by its cells as value, length pairs.
+func writeRuns(w io.ByteWriter, list []*Tile) {
+	writeVarint(w, int64(len(list)))
+	for _, t := range list {
+		writeVarint(w, int64(t.X))
+		writeVarint(w, int64(t.Y))
+		for i := 0; i < len(t.Cells); {
+			j := i
+			for j < len(t.Cells) && t.Cells[j] == t.Cells[i] {
+				j++
+			}
+			_ = w.WriteByte(t.Cells[i])
+			writeVarint(w, int64(j-i))
+			i = j
+		}
+	}
+}
+
+func readRuns(r *bufio.Reader) ([]*Tile, error) {
+	count, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// The count comes off the wire, so the list grows as tiles are read rather than trusting it
+	if count < 0 {
+		return nil, errors.New("negative tile count")
+	}
+	var list []*Tile
+	for n := int64(0); n < count; n++ {
+		x, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		y, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		t := NewTile(int(x), int(y))
+		for i := 0; i < len(t.Cells); {
+			value, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if length < 1 || length > int64(len(t.Cells)-i) {
+				return nil, errors.New("tile run overflows the tile")
+			}
+			for end := i + int(length); i < end; i++ {
+				t.Cells[i] = value
+			}
+		}
+		list = append(list, t)
+	}
+	_, _ = io.Copy(ioutil.Discard, r)
+	return list, nil
+}
+
+func writeVarint(w io.ByteWriter, v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, b := range buf[:binary.PutVarint(buf, v)] {
+		_ = w.WriteByte(b)
+	}
+}
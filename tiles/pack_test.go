@@ -0,0 +1,50 @@
+package tiles
+
+import (
+	"bytes"
+	"testing"
+)
+
+// varints writes each value as a varint, the way writeRuns does.
+func varints(values ...int64) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		writeVarint(&b, v)
+	}
+	return b.Bytes()
+}
+
+// TestUnpackMalformed checks tiles that were not packed by Pack are refused with an error rather than a panic.
+func TestUnpackMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		packed []byte
+	}{
+		{"empty", nil},
+		{"negative count", varints(-1)},
+		{"huge count", varints(1 << 62)},
+		{"missing cells", varints(1, 0, 0)},
+		{"run past the tile", append(varints(1, 0, 0), append([]byte{255}, varints(Size*Size+1)...)...)},
+		{"huge run", append(varints(1, 0, 0), append([]byte{255}, varints(1<<62)...)...)},
+		{"empty run", append(varints(1, 0, 0), append([]byte{255}, varints(0)...)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Unpack(test.packed, RLE); err == nil {
+				t.Errorf("expected %v to be refused", test.packed)
+			}
+		})
+	}
+
+	// A packed list still comes back as it went in
+	tile := NewTile(2, -3)
+	tile.Cells[5] = 255
+	packed, err := Pack([]*Tile{tile}, Flate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := Unpack(packed, Flate)
+	if err != nil || len(list) != 1 || list[0].X != 2 || list[0].Y != -3 || !bytes.Equal(list[0].Cells, tile.Cells) {
+		t.Errorf("expected the tile back, got %v, %v", list, err)
+	}
+}
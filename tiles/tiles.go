@@ -0,0 +1,578 @@
+package tiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Size is the width and height of a tile in cells.
+const Size = 64
+
+// Coord is the position of a tile counted in tiles, not cells.
+type Coord struct {
+	X, Y int
+}
+
+// Tile holds Size x Size cells row by row, alive cells are 255 and dead cells 0.
+type Tile struct {
+	X, Y  int
+	Cells []byte
+}
+
+// World is a board split into tiles. Tiles without any alive cells are left out,
+// so only the parts of a very large board that have some activity take up memory.
+// An Unbounded world is an infinite plane: nothing wraps around, tile coordinates may be
+// negative and Width and Height only describe the image the world was loaded from.
+type World struct {
+	Width, Height int
+	Unbounded     bool
+	Tiles         map[Coord]*Tile
+}
+
+// Bounds is a rectangle of cells, X and Y are the offset of its top left cell from the origin.
+type Bounds struct {
+	X, Y          int
+	Width, Height int
+}
+
+func NewTile(x, y int) *Tile {
+	return &Tile{X: x, Y: y, Cells: make([]byte, Size*Size)}
+}
+
+// Empty reports whether the tile has no alive cells.
+func (t *Tile) Empty() bool {
+	for _, cell := range t.Cells {
+		if cell != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func New(width, height int) *World {
+	return &World{Width: width, Height: height, Tiles: make(map[Coord]*Tile)}
+}
+
+// FromDense splits a height x width world into tiles.
+func FromDense(world [][]byte, width, height int) *World {
+	w := New(width, height)
+	for y := 0; y < height; y++ {
+		w.SetRow(y, world[y])
+	}
+	return w
+}
+
+// SetRow copies row y of the board into the world, allocating only the tiles it has alive cells in.
+func (w *World) SetRow(y int, row []byte) {
+	for x, cell := range row {
+		if cell != 0 {
+			w.Set(x, y, cell)
+		}
+	}
+}
+
+// FromList builds a world out of tiles received over the network.
+func FromList(width, height int, list []*Tile) *World {
+	w := New(width, height)
+	w.Add(list)
+	return w
+}
+
+// Add puts the given tiles into the world, replacing any tiles already at their positions.
+func (w *World) Add(list []*Tile) {
+	for _, t := range list {
+		if t != nil {
+			w.Tiles[Coord{t.X, t.Y}] = t
+		}
+	}
+}
+
+// List returns the tiles of the world ordered by row and then column.
+func (w *World) List() []*Tile {
+	list := make([]*Tile, 0, len(w.Tiles))
+	for _, t := range w.Tiles {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Y != list[j].Y {
+			return list[i].Y < list[j].Y
+		}
+		return list[i].X < list[j].X
+	})
+	return list
+}
+
+// Cols is the number of tile columns needed to cover the board.
+func (w *World) Cols() int {
+	return (w.Width + Size - 1) / Size
+}
+
+// Rows is the number of tile rows needed to cover the board.
+func (w *World) Rows() int {
+	return (w.Height + Size - 1) / Size
+}
+
+// RowRange is the range of tile rows that can have alive cells after the next turn.
+// On an infinite plane that is every row with a tile plus one row either side for growth.
+func (w *World) RowRange() (int, int) {
+	if !w.Unbounded {
+		return 0, w.Rows()
+	}
+	if len(w.Tiles) == 0 {
+		return 0, 0
+	}
+	list := w.List()
+	return list[0].Y - 1, list[len(list)-1].Y + 2
+}
+
+// ColRange is RowRange for tile columns.
+func (w *World) ColRange() (int, int) {
+	if !w.Unbounded {
+		return 0, w.Cols()
+	}
+	if len(w.Tiles) == 0 {
+		return 0, 0
+	}
+	first, last := 0, 0
+	started := false
+	for c := range w.Tiles {
+		if !started || c.X < first {
+			first = c.X
+		}
+		if !started || c.X > last {
+			last = c.X
+		}
+		started = true
+	}
+	return first - 1, last + 2
+}
+
+// Neighbour returns the position of the tile dx, dy tiles away from c, wrapping around the board.
+func (w *World) Neighbour(c Coord, dx, dy int) Coord {
+	if w.Unbounded {
+		return Coord{c.X + dx, c.Y + dy}
+	}
+	return Coord{mod(c.X+dx, w.Cols()), mod(c.Y+dy, w.Rows())}
+}
+
+// Strip returns the tiles in tile rows startRow to endRow, wrapping around the board.
+func (w *World) Strip(startRow, endRow int) []*Tile {
+	var strip []*Tile
+	if w.Unbounded {
+		for _, t := range w.List() {
+			if t.Y >= startRow && t.Y < endRow {
+				strip = append(strip, t)
+			}
+		}
+		return strip
+	}
+	rows := w.Rows()
+	if endRow-startRow >= rows {
+		return w.List()
+	}
+	inStrip := make(map[int]bool)
+	for y := startRow; y < endRow; y++ {
+		inStrip[mod(y, rows)] = true
+	}
+	for _, t := range w.List() {
+		if inStrip[t.Y] {
+			strip = append(strip, t)
+		}
+	}
+	return strip
+}
+
+// Active is every tile that can change next turn: the tiles that changed last turn and their neighbours.
+// Without a record of what changed, as on the first turn, every tile with alive cells counts as changed.
+func (w *World) Active(changed map[Coord]bool) map[Coord]bool {
+	if changed == nil {
+		changed = make(map[Coord]bool)
+		for c := range w.Tiles {
+			changed[c] = true
+		}
+	}
+	active := make(map[Coord]bool)
+	for c := range changed {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				active[w.Neighbour(c, dx, dy)] = true
+			}
+		}
+	}
+	return active
+}
+
+// Around returns the tiles at the given positions and next to them, which is all that is needed to work them out.
+func (w *World) Around(coords []Coord) []*Tile {
+	around := New(w.Width, w.Height)
+	for _, c := range coords {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				n := w.Neighbour(c, dx, dy)
+				if t := w.Tiles[n]; t != nil {
+					around.Tiles[n] = t
+				}
+			}
+		}
+	}
+	return around.List()
+}
+
+// Rect is a rectangle of cells in a tile, from X0, Y0 up to but not including X1, Y1.
+type Rect struct {
+	X0, Y0, X1, Y1 int
+}
+
+// Within returns the cells of tile c that are no more than depth cells from the tiles region reports as in it,
+// as rectangles that may overlap. A tile in the region is one rectangle covering all of it, and a tile that is
+// not next to the region has none. Only the tiles next to the region are looked at, so depth can be no more than
+// Narrowest.
+func (w *World) Within(c Coord, region func(Coord) bool, depth int) []Rect {
+	if region(c) {
+		return []Rect{{0, 0, Size, Size}}
+	}
+	if depth <= 0 {
+		return nil
+	}
+	width, height := w.extent(c)
+	var within []Rect
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if (dx != 0 || dy != 0) && region(w.Neighbour(c, dx, dy)) {
+				x0, x1 := edge(dx, depth, width)
+				y0, y1 := edge(dy, depth, height)
+				within = append(within, Rect{x0, y0, x1, y1})
+			}
+		}
+	}
+	return within
+}
+
+// edge is the range of cells along one side of a tile extent cells across that are within depth of the tile
+// next to it in direction d, every cell when d is 0.
+func edge(d int, depth int, extent int) (int, int) {
+	if depth > extent {
+		depth = extent
+	}
+	switch d {
+	case -1:
+		return 0, depth
+	case 1:
+		return extent - depth, extent
+	}
+	return 0, extent
+}
+
+// extent is how many cells across and down a tile is, less than Size for the last tiles of a board that is not
+// a whole number of tiles.
+func (w *World) extent(c Coord) (int, int) {
+	width, height := Size, Size
+	if !w.Unbounded && c.X == w.Cols()-1 && w.Width%Size != 0 {
+		width = w.Width % Size
+	}
+	if !w.Unbounded && c.Y == w.Rows()-1 && w.Height%Size != 0 {
+		height = w.Height % Size
+	}
+	return width, height
+}
+
+// Narrowest is the fewest cells across or down any tile of the world.
+func (w *World) Narrowest() int {
+	narrowest := Size
+	if !w.Unbounded && w.Width%Size != 0 && w.Width%Size < narrowest {
+		narrowest = w.Width % Size
+	}
+	if !w.Unbounded && w.Height%Size != 0 && w.Height%Size < narrowest {
+		narrowest = w.Height % Size
+	}
+	return narrowest
+}
+
+// Crop returns a copy of the tile with only the cells in the given rectangles, nil when none of those are alive.
+func (t *Tile) Crop(within []Rect) *Tile {
+	cropped := NewTile(t.X, t.Y)
+	alive := false
+	for _, r := range within {
+		for y := r.Y0; y < r.Y1; y++ {
+			row := t.Cells[y*Size+r.X0 : y*Size+r.X1]
+			copy(cropped.Cells[y*Size+r.X0:], row)
+			alive = alive || bytes.IndexByte(row, 255) >= 0
+		}
+	}
+	if !alive {
+		return nil
+	}
+	return cropped
+}
+
+// Copy returns a new world sharing the same tiles. Tiles are never changed once made so they can be shared.
+func (w *World) Copy() *World {
+	c := New(w.Width, w.Height)
+	c.Unbounded = w.Unbounded
+	for k, t := range w.Tiles {
+		c.Tiles[k] = t
+	}
+	return c
+}
+
+// InBoard reports whether x, y is a cell of the board rather than padding at the end of an edge tile.
+func (w *World) InBoard(x, y int) bool {
+	return w.Unbounded || (x >= 0 && y >= 0 && x < w.Width && y < w.Height)
+}
+
+// Get returns the cell at x, y. Coordinates outside the board wrap around.
+func (w *World) Get(x, y int) byte {
+	if !w.Unbounded {
+		x = mod(x, w.Width)
+		y = mod(y, w.Height)
+	}
+	t := w.Tiles[Coord{floorDiv(x, Size), floorDiv(y, Size)}]
+	if t == nil {
+		return 0
+	}
+	return t.Cells[mod(y, Size)*Size+mod(x, Size)]
+}
+
+// Set changes the cell at x, y, allocating its tile if needed.
+func (w *World) Set(x, y int, value byte) {
+	if !w.Unbounded {
+		x = mod(x, w.Width)
+		y = mod(y, w.Height)
+	}
+	c := Coord{floorDiv(x, Size), floorDiv(y, Size)}
+	t := w.Tiles[c]
+	if t == nil {
+		if value == 0 {
+			return
+		}
+		t = NewTile(c.X, c.Y)
+		w.Tiles[c] = t
+	}
+	t.Cells[mod(y, Size)*Size+mod(x, Size)] = value
+}
+
+// Bounds is the smallest rectangle holding every alive cell. The whole board is returned for
+// bounded worlds so images always keep their size.
+func (w *World) Bounds() Bounds {
+	if !w.Unbounded {
+		return Bounds{0, 0, w.Width, w.Height}
+	}
+	cells := w.AliveCells()
+	if len(cells) == 0 {
+		return Bounds{}
+	}
+	minX, minY, maxX, maxY := cells[0].X, cells[0].Y, cells[0].X, cells[0].Y
+	for _, cell := range cells {
+		if cell.X < minX {
+			minX = cell.X
+		}
+		if cell.X > maxX {
+			maxX = cell.X
+		}
+		if cell.Y < minY {
+			minY = cell.Y
+		}
+		if cell.Y > maxY {
+			maxY = cell.Y
+		}
+	}
+	return Bounds{minX, minY, maxX - minX + 1, maxY - minY + 1}
+}
+
+// Dense returns the world as a height x width slice of rows, or the bounding box of an infinite plane.
+func (w *World) Dense() [][]byte {
+	return w.DenseRegion(w.Bounds())
+}
+
+// DenseRegion returns the cells inside b as a slice of rows.
+func (w *World) DenseRegion(b Bounds) [][]byte {
+	world := make([][]byte, b.Height)
+	for i := range world {
+		world[i] = make([]byte, b.Width)
+	}
+	for _, t := range w.Tiles {
+		for r := 0; r < Size; r++ {
+			y := t.Y*Size + r - b.Y
+			if y < 0 || y >= b.Height || !w.InBoard(0, t.Y*Size+r) {
+				continue
+			}
+			for c := 0; c < Size; c++ {
+				x := t.X*Size + c - b.X
+				if x >= 0 && x < b.Width && w.InBoard(t.X*Size+c, 0) {
+					world[y][x] = t.Cells[r*Size+c]
+				}
+			}
+		}
+	}
+	return world
+}
+
+// EachRow calls f with every row of cells inside b from top to bottom, stopping at the first error, so a board
+// can be written out without ever being held densely. Every row is a new slice.
+func (w *World) EachRow(b Bounds, f func(row []byte) error) error {
+	list := w.List()
+	first := 0
+	for y := b.Y; y < b.Y+b.Height; y++ {
+		ty, r := floorDiv(y, Size), mod(y, Size)
+		for first < len(list) && list[first].Y < ty {
+			first++
+		}
+		row := make([]byte, b.Width)
+		for i := first; i < len(list) && list[i].Y == ty; i++ {
+			t := list[i]
+			for c := 0; c < Size; c++ {
+				x := t.X*Size + c - b.X
+				if x >= 0 && x < b.Width && w.InBoard(t.X*Size+c, y) {
+					row[x] = t.Cells[r*Size+c]
+				}
+			}
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash is a checksum of the alive cells, equal worlds always have the same hash. It is the sum of TileHash over
+// the tiles, so it can be kept up to date from just the tiles that change.
+func (w *World) Hash() uint64 {
+	var sum uint64
+	for c, t := range w.Tiles {
+		sum += TileHash(c, t)
+	}
+	return sum
+}
+
+// TileHash is a checksum of the tile at c, 0 for nil.
+func TileHash(c Coord, t *Tile) uint64 {
+	if t == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	coords := make([]byte, 16)
+	binary.LittleEndian.PutUint64(coords, uint64(c.X))
+	binary.LittleEndian.PutUint64(coords[8:], uint64(c.Y))
+	_, _ = h.Write(coords)
+	_, _ = h.Write(t.Cells)
+	return h.Sum64()
+}
+
+// Equal reports whether two worlds have exactly the same alive cells.
+func (w *World) Equal(other *World) bool {
+	if len(w.Tiles) != len(other.Tiles) {
+		return false
+	}
+	for c, t := range w.Tiles {
+		o := other.Tiles[c]
+		if o == nil || !bytes.Equal(t.Cells, o.Cells) {
+			return false
+		}
+	}
+	return true
+}
+
+// AliveCells lists every alive cell in the world.
+func (w *World) AliveCells() []util.Cell {
+	aliveCells := []util.Cell{}
+	for _, t := range w.List() {
+		for r := 0; r < Size; r++ {
+			for c := 0; c < Size; c++ {
+				if t.Cells[r*Size+c] == 255 {
+					aliveCells = append(aliveCells, util.Cell{X: t.X*Size + c, Y: t.Y*Size + r})
+				}
+			}
+		}
+	}
+	return aliveCells
+}
+
+// AliveCount is the number of alive cells in the world.
+func (w *World) AliveCount() int {
+	count := 0
+	for _, t := range w.Tiles {
+		for _, cell := range t.Cells {
+			if cell == 255 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// TileFlipped lists the cells that differ between two versions of the tile at c, nil being a tile with nothing alive.
+func TileFlipped(c Coord, old, new *Tile) []util.Cell {
+	var flipped []util.Cell
+	for i := 0; i < Size*Size; i++ {
+		var before, after byte
+		if old != nil {
+			before = old.Cells[i]
+		}
+		if new != nil {
+			after = new.Cells[i]
+		}
+		if before != after {
+			flipped = append(flipped, util.Cell{X: c.X*Size + i%Size, Y: c.Y*Size + i/Size})
+		}
+	}
+	return flipped
+}
+
+// Flipped lists every cell that differs between w and next.
+func (w *World) Flipped(next *World) []util.Cell {
+	var flipped []util.Cell
+	for c, t := range w.Tiles {
+		if n := next.Tiles[c]; n != t {
+			flipped = append(flipped, TileFlipped(c, t, n)...)
+		}
+	}
+	for c, n := range next.Tiles {
+		if w.Tiles[c] == nil {
+			flipped = append(flipped, TileFlipped(c, nil, n)...)
+		}
+	}
+	return flipped
+}
+
+// CoordOf is the position of the tile holding a cell.
+func CoordOf(cell util.Cell) Coord {
+	return Coord{floorDiv(cell.X, Size), floorDiv(cell.Y, Size)}
+}
+
+// Flip inverts the given cells. Each tile touched is copied first, so worlds sharing it are left alone,
+// and tiles with nothing left alive are dropped.
+func (w *World) Flip(cells []util.Cell) {
+	copied := make(map[Coord]*Tile)
+	for _, cell := range cells {
+		c := CoordOf(cell)
+		t := copied[c]
+		if t == nil {
+			t = NewTile(c.X, c.Y)
+			if old := w.Tiles[c]; old != nil {
+				copy(t.Cells, old.Cells)
+			}
+			copied[c] = t
+		}
+		i := mod(cell.Y, Size)*Size + mod(cell.X, Size)
+		t.Cells[i] = ^t.Cells[i]
+	}
+	for c, t := range copied {
+		if t.Empty() {
+			delete(w.Tiles, c)
+		} else {
+			w.Tiles[c] = t
+		}
+	}
+}
+
+func mod(a, b int) int {
+	return ((a % b) + b) % b
+}
+
+func floorDiv(a, b int) int {
+	return (a - mod(a, b)) / b
+}
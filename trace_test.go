@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime/trace"
 	"testing"
 	"uk.ac.bris.cs/gameoflife/gol"
@@ -27,3 +31,70 @@ func TestTrace(t *testing.T) {
 	err = f.Close()
 	util.Check(err)
 }
+
+// TestTurnTrace traces 512x512 for 10 turns and checks every turn was timed on the broker,
+// with each worker's strip placed inside the call the broker made for it.
+func TestTurnTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol-trace")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 10, Threads: 4, TraceFile: filepath.Join(dir, "trace.json")}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for range events {
+	}
+
+	data, err := ioutil.ReadFile(p.TraceFile)
+	util.Check(err)
+	var trace struct {
+		TraceEvents []struct {
+			Name string
+			Ph   string
+			Ts   float64
+			Dur  float64
+			Pid  int
+			Tid  int
+			Args map[string]interface{}
+		}
+	}
+	util.Check(json.Unmarshal(data, &trace))
+
+	type call struct{ start, end float64 }
+	calls := make(map[string]call)
+	var strips []call
+	var stripKeys []string
+	turns := 0
+	for _, e := range trace.TraceEvents {
+		if e.Ph != "X" {
+			continue
+		}
+		key := fmt.Sprint(e.Args["trace"])
+		switch {
+		case e.Name == "turn" && e.Pid == 1:
+			turns++
+		case e.Name == "call" && e.Pid == 1:
+			calls[key+fmt.Sprint(e.Tid)] = call{e.Ts, e.Ts + e.Dur}
+		case e.Name == "strip" && e.Pid != 1:
+			strips = append(strips, call{e.Ts, e.Ts + e.Dur})
+			stripKeys = append(stripKeys, key)
+		}
+	}
+	if turns != 10 {
+		t.Errorf("expected 10 turns traced, got %d", turns)
+	}
+	if len(strips) == 0 {
+		t.Fatal("expected strips traced on the workers, got none")
+	}
+	for i, s := range strips {
+		inside := false
+		for lane := 1; lane <= 4; lane++ {
+			c, ok := calls[stripKeys[i]+fmt.Sprint(lane)]
+			if ok && s.start >= c.start-1 && s.end <= c.end+1 {
+				inside = true
+			}
+		}
+		if !inside {
+			t.Errorf("strip of %s at %v-%v is not inside any call made for it", stripKeys[i], s.start, s.end)
+		}
+	}
+}
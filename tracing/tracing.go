@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Broker is the node name used for spans recorded by the broker.
+const Broker = "broker"
+
+// maxSpans is how many spans a Recorder keeps. Older ones are dropped first, so long runs keep their last turns.
+const maxSpans = 200000
+
+// Span is one timed piece of work. Spans on the same node and lane nest by time, so a worker's calculation
+// shows up inside the call the broker made to it.
+type Span struct {
+	Name string
+	// Trace is the ID of the turn the span belongs to
+	Trace    string
+	Node     string
+	Lane     int
+	Start    time.Time
+	Duration time.Duration
+	Args     map[string]int `json:",omitempty"`
+}
+
+// ID is the trace ID for a turn, made from the session so it can be looked up in the logs as well.
+func ID(session string, turn int) string {
+	return fmt.Sprintf("%s/%d", session, turn)
+}
+
+// Since makes a span that started at start and ends now.
+func Since(name string, trace string, node string, lane int, start time.Time) Span {
+	return Span{Name: name, Trace: trace, Node: node, Lane: lane, Start: start, Duration: time.Since(start)}
+}
+
+// Align moves spans recorded on another machine onto this machine's clock. The first span has to cover the
+// others, it is placed in the middle of the call that was made to get them, taking the time spent getting
+// there and back to be the same both ways. Clocks on different machines are never compared directly.
+func Align(remote []Span, callStart time.Time, callEnd time.Time) []Span {
+	if len(remote) == 0 {
+		return nil
+	}
+	outer := remote[0]
+	travel := callEnd.Sub(callStart) - outer.Duration
+	if travel < 0 {
+		travel = 0
+	}
+	shift := callStart.Add(travel / 2).Sub(outer.Start)
+	aligned := make([]Span, len(remote))
+	for i, s := range remote {
+		s.Start = s.Start.Add(shift)
+		aligned[i] = s
+	}
+	return aligned
+}
+
+// Recorder keeps the spans of a run.
+type Recorder struct {
+	mu      sync.Mutex
+	enabled bool
+	spans   []Span
+}
+
+// Start clears the recorder, then keeps spans from now on if enabled.
+func (r *Recorder) Start(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enabled = enabled
+	r.spans = nil
+}
+
+func (r *Recorder) Enabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enabled
+}
+
+func (r *Recorder) Add(spans ...Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled {
+		return
+	}
+	r.spans = append(r.spans, spans...)
+	if len(r.spans) > maxSpans {
+		r.spans = append([]Span(nil), r.spans[len(r.spans)-maxSpans/2:]...)
+	}
+}
+
+// Spans returns a copy of everything recorded.
+func (r *Recorder) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Span(nil), r.spans...)
+}
+
+// chromeEvent is one entry of the Chrome trace format, as read by chrome://tracing and Perfetto.
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Time  float64                `json:"ts"`
+	Dur   float64                `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteChrome writes the spans as a Chrome trace. Each node is a process, named after it, with the broker first,
+// and times are in microseconds from the first span.
+func WriteChrome(w io.Writer, spans []Span) error {
+	spans = append([]Span(nil), spans...)
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	pids := map[string]int{Broker: 1}
+	events := []chromeEvent{{Name: "process_name", Phase: "M", Pid: 1, Args: map[string]interface{}{"name": Broker}}}
+	var origin time.Time
+	if len(spans) > 0 {
+		origin = spans[0].Start
+	}
+	for _, s := range spans {
+		pid, ok := pids[s.Node]
+		if !ok {
+			pid = len(pids) + 1
+			pids[s.Node] = pid
+			events = append(events, chromeEvent{Name: "process_name", Phase: "M", Pid: pid, Args: map[string]interface{}{"name": s.Node}})
+		}
+		args := map[string]interface{}{"trace": s.Trace}
+		for k, v := range s.Args {
+			args[k] = v
+		}
+		events = append(events, chromeEvent{
+			Name:  s.Name,
+			Cat:   "gol",
+			Phase: "X",
+			Time:  float64(s.Start.Sub(origin).Nanoseconds()) / 1000,
+			Dur:   float64(s.Duration.Nanoseconds()) / 1000,
+			Pid:   pid,
+			Tid:   s.Lane,
+			Args:  args,
+		})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []chromeEvent `json:"traceEvents"`
+		DisplayTimeUnit string        `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/rpc"
+	"reflect"
+)
+
+// errToken is returned for calls made without the server's token.
+var errToken = errors.New("invalid token")
+
+// gobClientCodec is net/rpc's gob codec with the token sent after every request header.
+type gobClientCodec struct {
+	conn  io.ReadWriteCloser
+	dec   *gob.Decoder
+	enc   *gob.Encoder
+	buf   *bufio.Writer
+	token string
+}
+
+func newClientCodec(conn io.ReadWriteCloser, reader io.Reader, token string) *gobClientCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobClientCodec{conn, gob.NewDecoder(reader), gob.NewEncoder(buf), buf, token}
+}
+
+func (c *gobClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(c.token); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *gobClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobClientCodec) Close() error {
+	return c.conn.Close()
+}
+
+// gobServerCodec reads the token after each request header. A call with the wrong token has its
+// arguments thrown away and gets errToken back without the method being run.
+type gobServerCodec struct {
+	conn    io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	buf     *bufio.Writer
+	tokens  tokens
+	role    string
+	allowed bool
+}
+
+func newServerCodec(conn io.ReadWriteCloser, tokens tokens) *gobServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{conn: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), buf: buf, tokens: tokens}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	var token string
+	if err := c.dec.Decode(&token); err != nil {
+		return err
+	}
+	c.role, c.allowed = c.tokens.role(token)
+	return nil
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	if !c.allowed {
+		if err := c.dec.DecodeValue(reflect.Value{}); err != nil {
+			return err
+		}
+		return errToken
+	}
+	err := c.dec.Decode(body)
+	setRole(body, c.role)
+	return err
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = c.enc.Encode(body)
+	}
+	if err == nil {
+		err = c.buf.Flush()
+	}
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+func (c *gobServerCodec) Close() error {
+	return c.conn.Close()
+}
+
+// roleCodec fills in the caller's role on requests read by another codec.
+type roleCodec struct {
+	rpc.ServerCodec
+	role string
+}
+
+func (c *roleCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	setRole(body, c.role)
+	return err
+}
+
+// setRole overwrites the role in arguments that have one, so a client cannot pick its own.
+func setRole(body interface{}, role string) {
+	if caller, ok := body.(interface{ SetRole(string) }); ok {
+		caller.SetRole(role)
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// handlers are the plain HTTP endpoints added with Handle.
+var handlers = http.NewServeMux()
+
+// pages are the patterns added with HandlePage.
+var pages = map[string]bool{}
+
+// roleKey is where serveHandler keeps the caller's role in a request's context.
+type roleKey struct{}
+
+// Handle serves plain HTTP requests for pattern on every listener Serve answers, next to the RPC calls, for
+// clients that cannot speak either protocol. Requests need a token the same way metrics do but no protocol
+// version. Handlers find out whose token it was with CallerRole.
+func Handle(pattern string, handler http.Handler) {
+	handlers.Handle(pattern, handler)
+}
+
+// HandlePage is like Handle for pages opened in a browser, which cannot send headers when loading a page or an
+// event stream, so a GET may give its token in the address instead: ?token=...
+func HandlePage(pattern string, handler http.Handler) {
+	pages[pattern] = true
+	handlers.Handle(pattern, handler)
+}
+
+// CallerRole is the role of whoever made a request passed to a handler added with Handle.
+func CallerRole(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey{}).(string)
+	return role
+}
+
+// handled reports whether a request is for one of the endpoints added with Handle.
+func handled(r *http.Request) bool {
+	_, pattern := handlers.Handler(r)
+	return pattern != ""
+}
+
+func serveHandler(w http.ResponseWriter, r *http.Request, tokens tokens) {
+	token := requestToken(r)
+	if _, pattern := handlers.Handler(r); token == "" && r.Method == "GET" && pages[pattern] {
+		token = r.URL.Query().Get("token")
+	}
+	role, ok := tokens.role(token)
+	if !ok {
+		http.Error(w, errToken.Error(), http.StatusUnauthorized)
+		return
+	}
+	handlers.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+}
+
+// requestToken is the token sent in the headers of a plain HTTP request. Tools like Prometheus cannot send
+// X-Gol-Token but can send a bearer token, so that is taken as well.
+func requestToken(r *http.Request) string {
+	if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		return strings.TrimPrefix(bearer, "Bearer ")
+	}
+	return r.Header.Get(tokenHeader)
+}
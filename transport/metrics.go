@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/metrics"
+)
+
+// incoming is the peer label for connections accepted by Serve, which are counted together.
+const incoming = "incoming"
+
+var callSeconds = metrics.NewHistogram("gol_rpc_seconds", "Time taken by calls made to other nodes.", "method", "peer")
+var callErrors = metrics.NewCounter("gol_rpc_errors_total", "Calls to other nodes that failed.", "method", "peer")
+var sentBytes = metrics.NewCounter("gol_sent_bytes_total", "Bytes written to connections, TLS included.", "peer")
+var receivedBytes = metrics.NewCounter("gol_received_bytes_total", "Bytes read from connections, TLS included.", "peer")
+
+// timedClient records how long every call through it takes.
+type timedClient struct {
+	Client
+	peer string
+}
+
+func (c *timedClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := c.Client.Call(serviceMethod, args, reply)
+	callSeconds.Observe(time.Since(start).Seconds(), serviceMethod, c.peer)
+	if err != nil {
+		callErrors.Add(1, serviceMethod, c.peer)
+	}
+	return err
+}
+
+// countingConn counts the bytes going through a connection under the given peer.
+type countingConn struct {
+	net.Conn
+	peer string
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	receivedBytes.Add(float64(n), c.peer)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	sentBytes.Add(float64(n), c.peer)
+	return n, err
+}
+
+// countingListener counts the bytes of every connection it accepts.
+type countingListener struct {
+	net.Listener
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, incoming}, nil
+}
+
+// countingDial is used by the JSON client's HTTP transport so its connections are counted too.
+func countingDial(ctx context.Context, network string, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, address}, nil
+}
+
+// serveMetrics answers GET /metrics. Observers can read metrics as well as operators.
+func serveMetrics(w http.ResponseWriter, r *http.Request, tokens tokens) {
+	if _, ok := tokens.role(requestToken(r)); !ok {
+		http.Error(w, errToken.Error(), http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
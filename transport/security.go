@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// Security is how connections are protected. With CAFile set every connection is TLS and both ends have to
+// present a certificate signed by that CA, CertFile and KeyFile being this end's own. With Token set it is sent
+// with every call, and calls without it are refused. Servers also take ObserverToken in place of Token,
+// calls made with it are marked as coming from an observer.
+type Security struct {
+	CertFile      string
+	KeyFile       string
+	CAFile        string
+	Token         string
+	ObserverToken string
+}
+
+// SecurityFlags adds the -cert, -key, -ca and -token flags every binary takes. The token defaults to $GOL_TOKEN
+// so it does not have to show up in the process list.
+func SecurityFlags() *Security {
+	s := &Security{}
+	flag.StringVar(&s.CertFile, "cert", "", "Certificate to present, turns on TLS together with -key and -ca")
+	flag.StringVar(&s.KeyFile, "key", "", "Private key of the certificate")
+	flag.StringVar(&s.CAFile, "ca", "", "CA the other end's certificate has to be signed by")
+	flag.StringVar(&s.Token, "token", os.Getenv("GOL_TOKEN"), "Shared secret sent with every call")
+	flag.StringVar(&s.ObserverToken, "observerToken", os.Getenv("GOL_OBSERVER_TOKEN"), "Secret that lets callers watch but not control runs")
+	return s
+}
+
+// settings are what Configure last set up. Serve takes a copy when it starts so a process can go on
+// serving with one setup while dialling with another.
+type settings struct {
+	server *tls.Config
+	client *tls.Config
+	tokens tokens
+}
+
+var security settings
+var securityMu sync.Mutex
+
+func current() settings {
+	securityMu.Lock()
+	defer securityMu.Unlock()
+	return security
+}
+
+// tokens are the secrets a server takes, one for each role.
+type tokens struct {
+	operator string
+	observer string
+}
+
+// Configure sets up TLS and the token used by Dial and by every Serve started after it.
+// An empty Security goes back to plain connections without a token.
+func Configure(s Security) error {
+	next := settings{tokens: tokens{s.Token, s.ObserverToken}}
+	if s.CAFile == "" {
+		if s.CertFile != "" || s.KeyFile != "" {
+			return errors.New("a certificate was given without the CA to check the other end against")
+		}
+		securityMu.Lock()
+		security = next
+		securityMu.Unlock()
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return err
+	}
+	ca, err := ioutil.ReadFile(s.CAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificates found in %s", s.CAFile)
+	}
+	next.server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	next.client = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}
+	securityMu.Lock()
+	security = next
+	securityMu.Unlock()
+	return nil
+}
+
+// clientTLS returns the client config for an address, or nil when TLS is off.
+// Addresses like :8040 from workers.txt have no host, so they are checked as localhost.
+func clientTLS(address string) (*tls.Config, error) {
+	client := current().client
+	if client == nil {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	config := client.Clone()
+	config.ServerName = host
+	return config, nil
+}
+
+// role works out who a token belongs to, comparing in constant time. Without an operator token
+// on the server there is nothing to check and everyone is an operator.
+func (t tokens) role(given string) (string, bool) {
+	switch {
+	case t.operator == "" || subtle.ConstantTimeCompare([]byte(given), []byte(t.operator)) == 1:
+		return stubs.Operator, true
+	case t.observer != "" && subtle.ConstantTimeCompare([]byte(given), []byte(t.observer)) == 1:
+		return stubs.Observer, true
+	}
+	return "", false
+}
@@ -0,0 +1,328 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strconv"
+	"strings"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// Gob is the compact binary protocol, net/rpc gob over a plain TCP connection.
+// JSON is JSON-RPC 1.0 over HTTP, one POST per call, so it can be used from anything that speaks HTTP.
+const (
+	Gob  = "gob"
+	JSON = "json"
+)
+
+// versionHeader carries the protocol version on every JSON request, tokenHeader the token.
+const versionHeader = "X-Gol-Protocol"
+const tokenHeader = "X-Gol-Token"
+
+// Client is what the controller and broker make calls through, whichever protocol is underneath.
+type Client interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Close() error
+}
+
+// Dial connects to a broker or worker with the given protocol, checking both ends speak the same version.
+func Dial(protocol string, address string) (Client, error) {
+	var client Client
+	var err error
+	switch protocol {
+	case Gob, "":
+		client, err = dialGob(address)
+	case JSON:
+		client, err = dialJSON(address)
+	default:
+		return nil, fmt.Errorf("unknown transport %q, expected %s or %s", protocol, Gob, JSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timedClient{client, address}, nil
+}
+
+func dialGob(address string) (Client, error) {
+	config, err := clientTLS(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	// Counted underneath TLS, as Serve does
+	conn = &countingConn{conn, address}
+	if config != nil {
+		secure := tls.Client(conn, config)
+		err = secure.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = secure
+	}
+	_, err = fmt.Fprintf(conn, "GOL %d %s\n", stubs.Version, Gob)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s did not answer the protocol handshake, it may be an older build: %v", address, err)
+	}
+	if !strings.HasPrefix(reply, "OK") {
+		conn.Close()
+		return nil, fmt.Errorf("%s refused the connection: %s", address, strings.TrimSpace(strings.TrimPrefix(reply, "ERR")))
+	}
+	return rpc.NewClientWithCodec(newClientCodec(conn, reader, current().tokens.operator)), nil
+}
+
+// jsonClient makes one HTTP request per call.
+type jsonClient struct {
+	url   string
+	http  *http.Client
+	token string
+	mu    sync.Mutex
+	next  uint64
+}
+
+func dialJSON(address string) (Client, error) {
+	c := &jsonClient{url: "http://" + address, token: current().tokens.operator}
+	config, err := clientTLS(address)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		c.url = "https://" + address
+	}
+	c.http = &http.Client{Transport: &http.Transport{DialContext: countingDial, TLSClientConfig: config}}
+	request, err := http.NewRequest("GET", c.url+"/version", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(versionHeader, strconv.Itoa(stubs.Version))
+	request.Header.Set(tokenHeader, c.token)
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s refused the connection: %s", address, readError(response))
+	}
+	return c, nil
+}
+
+func (c *jsonClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	c.mu.Lock()
+	id := c.next
+	c.next++
+	c.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"method": serviceMethod,
+		"params": []interface{}{args},
+		"id":     id,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", c.url+"/rpc", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(versionHeader, strconv.Itoa(stubs.Version))
+	request.Header.Set(tokenHeader, c.token)
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New(readError(response))
+	}
+
+	var result struct {
+		Result json.RawMessage
+		Error  interface{}
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if result.Error != nil {
+		return rpc.ServerError(fmt.Sprint(result.Error))
+	}
+	return json.Unmarshal(result.Result, reply)
+}
+
+func (c *jsonClient) Close() error {
+	return nil
+}
+
+func readError(response *http.Response) string {
+	message, _ := ioutil.ReadAll(response.Body)
+	return fmt.Sprintf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+}
+
+// Serve answers both protocols on one listener. Each connection is told apart by its first bytes:
+// a gob client starts with the GOL handshake line, anything else is taken to be HTTP.
+// With TLS configured the listener only takes TLS connections and the same happens inside them.
+func Serve(listener net.Listener, server *rpc.Server) error {
+	security := current()
+	tokens := security.tokens
+	// Counted underneath TLS so the bytes are the ones that actually went over the network
+	listener = &countingListener{listener}
+	if security.server != nil {
+		listener = tls.NewListener(listener, security.server)
+	}
+	httpConns := &connListener{conns: make(chan net.Conn), addr: listener.Addr()}
+	go http.Serve(httpConns, &jsonHandler{server, tokens})
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go route(conn, server, httpConns, tokens)
+	}
+}
+
+func route(conn net.Conn, server *rpc.Server, httpConns *connListener, tokens tokens) {
+	reader := bufio.NewReader(conn)
+	start, err := reader.Peek(4)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	buffered := &bufferedConn{conn, reader}
+	if string(start) != "GOL " {
+		httpConns.conns <- buffered
+		return
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	var version int
+	var protocol string
+	_, err = fmt.Sscanf(line, "GOL %d %s", &version, &protocol)
+	switch {
+	case err != nil:
+		fmt.Fprintf(conn, "ERR malformed handshake %q\n", strings.TrimSpace(line))
+	case version != stubs.Version:
+		fmt.Fprintf(conn, "ERR protocol version mismatch, this build speaks v%d and the client speaks v%d\n", stubs.Version, version)
+	case protocol != Gob:
+		fmt.Fprintf(conn, "ERR unknown protocol %q over tcp, expected %s\n", protocol, Gob)
+	default:
+		fmt.Fprintf(conn, "OK %d\n", stubs.Version)
+		server.ServeCodec(newServerCodec(buffered, tokens))
+		return
+	}
+	logging.Warn("refused connection", "from", conn.RemoteAddr(), "handshake", strings.TrimSpace(line))
+	conn.Close()
+}
+
+// jsonHandler serves JSON-RPC calls posted to /rpc, version checks on /version, metrics on /metrics and anything
+// added with Handle.
+type jsonHandler struct {
+	server *rpc.Server
+	tokens tokens
+}
+
+func (h *jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Metrics are scraped by tools that know nothing about protocol versions
+	if r.URL.Path == "/metrics" {
+		serveMetrics(w, r, h.tokens)
+		return
+	}
+	if handled(r) {
+		serveHandler(w, r, h.tokens)
+		return
+	}
+	version := r.Header.Get(versionHeader)
+	if version != strconv.Itoa(stubs.Version) {
+		http.Error(w, fmt.Sprintf("protocol version mismatch, this build speaks v%d and the client speaks v%q", stubs.Version, version), http.StatusConflict)
+		return
+	}
+	role, ok := h.tokens.role(r.Header.Get(tokenHeader))
+	if !ok {
+		http.Error(w, errToken.Error(), http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/version":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{\"version\":%d}\n", stubs.Version)
+	case "/rpc":
+		if r.Method != "POST" {
+			http.Error(w, "calls must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := h.server.ServeRequest(&roleCodec{jsonrpc.NewServerCodec(&httpBody{r.Body, w}), role})
+		if err != nil {
+			logging.Warn("json call failed", "from", r.RemoteAddr, "err", err)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// httpBody lets the JSON-RPC codec read a request body and write the response.
+type httpBody struct {
+	io.Reader
+	io.Writer
+}
+
+func (b *httpBody) Close() error {
+	return nil
+}
+
+// bufferedConn is a connection whose first bytes have already been read into reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// connListener hands the connections that turned out to be HTTP to the HTTP server.
+type connListener struct {
+	conns chan net.Conn
+	addr  net.Addr
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	return <-l.conns, nil
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
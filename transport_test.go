@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestTransport tests 16x16 and 64x64 images on 0, 1 and 100 turns talking to the broker over JSON-RPC.
+func TestTransport(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			p.Threads = 4
+			p.Transport = "json"
+			testName := fmt.Sprintf("%dx%dx%d-%s", p.ImageWidth, p.ImageHeight, p.Turns, p.Transport)
+			t.Run(testName, func(t *testing.T) {
+				runGolden(t, p)
+			})
+		}
+	}
+}
+
+// TestTransportVersion checks the broker refuses a client speaking a different protocol version.
+func TestTransportVersion(t *testing.T) {
+	conn, err := net.Dial("tcp", "127.0.0.1:8030")
+	util.Check(err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "GOL 0 gob\n")
+	util.Check(err)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	util.Check(err)
+	if !strings.HasPrefix(reply, "ERR protocol version mismatch") {
+		t.Fatalf("expected a version mismatch, got %q", reply)
+	}
+}
+
+// TestOldWorker checks a worker built before the last change to the wire types is refused over both protocols,
+// rather than answering calls whose fields it does not know about.
+func TestOldWorker(t *testing.T) {
+	old := stubs.Version - 1
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	util.Check(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var version int
+			var protocol string
+			fmt.Fscanf(conn, "GOL %d %s\n", &version, &protocol)
+			if version != old {
+				fmt.Fprintf(conn, "ERR protocol version mismatch, this build speaks v%d and the client speaks v%d\n", old, version)
+			}
+			conn.Close()
+		}
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if version := r.Header.Get("X-Gol-Protocol"); version != strconv.Itoa(old) {
+			http.Error(w, fmt.Sprintf("protocol version mismatch, this build speaks v%d and the client speaks v%q", old, version), http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	for protocol, address := range map[string]string{
+		transport.Gob:  listener.Addr().String(),
+		transport.JSON: strings.TrimPrefix(server.URL, "http://"),
+	} {
+		t.Run(protocol, func(t *testing.T) {
+			client, err := transport.Dial(protocol, address)
+			if err == nil {
+				err = client.Call(stubs.EvolveWorldHandler, stubs.WorldReq{}, &stubs.WorldRes{})
+				client.Close()
+			}
+			if err == nil || !strings.Contains(err.Error(), "protocol version mismatch") {
+				t.Fatalf("expected the old worker to be refused, got %v", err)
+			}
+		})
+	}
+}
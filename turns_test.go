@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// TestTurnsPerCall runs the check images with the workers working out several turns per call, in strips and
+// blocks. 100 turns in calls of 64 leaves a shorter last call.
+func TestTurnsPerCall(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, decomposition := range []string{stubs.StripsDecomposition, stubs.BlocksDecomposition} {
+			for _, perCall := range []int{8, 64} {
+				for _, turns := range []int{1, 100} {
+					p.Turns = turns
+					p.Threads = 4
+					p.Decomposition = decomposition
+					p.TurnsPerCall = perCall
+					t.Run(fmt.Sprintf("%dx%dx%d-%s-%d", p.ImageWidth, p.ImageHeight, p.Turns, decomposition, perCall), func(t *testing.T) {
+						runGolden(t, p)
+					})
+				}
+			}
+		}
+	}
+}
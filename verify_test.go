@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// TestVerify runs the check images with every strip worked out twice, in strips and blocks, and checks the
+// workers never disagreed.
+func TestVerify(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, decomposition := range []string{stubs.StripsDecomposition, stubs.BlocksDecomposition} {
+			p.Turns = 100
+			p.Threads = 4
+			p.Decomposition = decomposition
+			p.Verify = true
+			t.Run(fmt.Sprintf("%dx%dx%d-%s", p.ImageWidth, p.ImageHeight, p.Turns, decomposition), func(t *testing.T) {
+				if divergences := runGolden(t, p); len(divergences) > 0 {
+					t.Errorf("expected the workers to agree, got %v", divergences)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestViewer checks the broker serves the viewer page, then runs a 64x64 board for 100 turns through the HTTP
+// API and checks the first frame streamed to the viewer is the whole final board.
+func TestViewer(t *testing.T) {
+	code, page := callAPI(t, "GET", "/viewer", nil)
+	if code != http.StatusOK || !strings.Contains(string(page), "<canvas") {
+		t.Fatalf("expected the viewer page, got %d %s", code, page)
+	}
+
+	image, err := ioutil.ReadFile("images/64x64.pgm")
+	util.Check(err)
+	code, body := callAPI(t, "POST", "/api/runs?turns=100&threads=4", image)
+	if code != http.StatusAccepted {
+		t.Fatalf("expected the run to start, got %d %s", code, body)
+	}
+	apiFinished(t)
+
+	response, err := http.Get("http://127.0.0.1:8030/viewer/events")
+	if err != nil {
+		t.Fatalf("could not open the event stream: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", response.Header.Get("Content-Type"))
+	}
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	util.Check(err)
+	var frame struct {
+		Turn   int
+		Full   bool
+		Width  int
+		Height int
+		Cells  []int
+	}
+	util.Check(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame))
+	if !frame.Full || frame.Turn != 100 || frame.Width != 64 || frame.Height != 64 {
+		t.Fatalf("expected a full frame of the 64x64 board on turn 100, got turn %d full %v %dx%d",
+			frame.Turn, frame.Full, frame.Width, frame.Height)
+	}
+	var cells []util.Cell
+	for i := 0; i+1 < len(frame.Cells); i += 2 {
+		cells = append(cells, util.Cell{X: frame.Cells[i], Y: frame.Cells[i+1]})
+	}
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100}
+	assertEqualBoard(t, cells, readAliveCells("check/images/64x64x100.pgm", 64, 64), p)
+}
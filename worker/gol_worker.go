@@ -1,75 +1,337 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"net"
 	"net/rpc"
 	"os"
+	"sync/atomic"
+	"time"
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/metrics"
 	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/tracing"
+	"uk.ac.bris.cs/gameoflife/transport"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 var kill = make(chan bool)
 
+var calculateSeconds = metrics.NewHistogram("gol_calculate_seconds", "Time spent working out a strip, not counting the call itself.")
+var tilesCalculated = metrics.NewCounter("gol_tiles_calculated_total", "Active tiles worked out.")
+var tilesChanged = metrics.NewCounter("gol_tiles_changed_total", "Tiles that came out different from how they went in.")
+var busyGauge = metrics.NewGauge("gol_busy_strips", "Strips being worked out right now.")
+var busy int64
+
 type WorldOps struct {
+	// peer is this worker's part in a run without a broker, leading is the run it leads if it was elected
+	peer    peer
+	leading leading
 }
 
 func (w *WorldOps) CalculateWorld(req *stubs.WorldReq, res *stubs.WorldRes) (err error) {
-	res.World = calculateNextState(req.World, req.Width, req.Height, req.StartRow, req.EndRow)
+	if err = req.Check(stubs.Operator, "calculate turns"); err != nil {
+		return
+	}
+	if req.Turns > tiles.Size {
+		return fmt.Errorf("cannot work out %d turns at once, at most %d can be", req.Turns, tiles.Size)
+	}
+	start := time.Now()
+	busyGauge.Set(float64(atomic.AddInt64(&busy, 1)))
+	defer func() {
+		busyGauge.Set(float64(atomic.AddInt64(&busy, -1)))
+		calculateSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	// Each step is timed when the turn is being traced
+	var spans []tracing.Span
+	step := func(name string, from time.Time) time.Time {
+		if req.Trace != "" {
+			spans = append(spans, tracing.Since(name, req.Trace, "", 0, from))
+		}
+		return time.Now()
+	}
+
+	from := start
+	list := req.Tiles
+	if req.Encoding != "" {
+		list, err = tiles.Unpack(req.Packed, req.Encoding)
+		if err != nil {
+			logging.Error("could not unpack strip", "session", req.Session, "turn", req.Turn, "encoding", req.Encoding, "err", err)
+			return
+		}
+		from = step("unpack", from)
+	}
+	world := tiles.FromList(req.Width, req.Height, list)
+	world.Unbounded = req.Unbounded
+	if req.Turns > 1 {
+		res.Tiles, res.Changed, res.LastChanged = calculateTurns(world, inRegion(req), req.Active, req.Turns)
+	} else {
+		res.Tiles, res.Changed = calculateNextState(world, inRegion(req), req.Active)
+	}
+	tilesChanged.Add(float64(len(res.Changed)))
+	from = step("calculate", from)
+	if req.Deltas {
+		res.Tiles, res.Flipped = splitDeltas(world, res.Tiles, res.Changed)
+		from = step("deltas", from)
+	}
+
+	// The result goes back packed the same way the strip came in
+	if req.Encoding != "" {
+		res.Packed, err = tiles.Pack(res.Tiles, req.Encoding)
+		res.Tiles = nil
+		step("pack", from)
+	}
+	if req.Trace != "" {
+		strip := tracing.Since("strip", req.Trace, "", 0, start)
+		strip.Args = map[string]int{"active": len(req.Active), "changed": len(res.Changed), "flipped": len(res.Flipped)}
+		res.Spans = append([]tracing.Span{strip}, spans...)
+	}
+	logging.Debug("strip calculated", "session", req.Session, "turn", req.Turn, "startRow", req.StartRow, "endRow", req.EndRow,
+		"startCol", req.StartCol, "endCol", req.EndCol, "active", len(req.Active), "changed", len(res.Changed), "took", time.Since(start))
+	return
+}
+
+// Encodings tells the broker which tile encodings this worker understands.
+func (w *WorldOps) Encodings(req *stubs.Empty, res *stubs.EncodingsResponse) (err error) {
+	if err = req.Check(stubs.Observer, "list encodings"); err != nil {
+		return
+	}
+	res.Encodings = tiles.Encodings
 	return
 }
 
+// Heartbeat answers the broker checking the worker is still there.
+func (w *WorldOps) Heartbeat(req *stubs.Empty, res *stubs.Empty) (err error) {
+	return req.Check(stubs.Observer, "send heartbeats")
+}
+
 func (w *WorldOps) KillWorker(req *stubs.Empty, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "kill workers"); err != nil {
+		return
+	}
+	logging.Info("killed")
 	kill <- true
 	return
 }
 
-func calculateNextState(world [][]byte, width int, height int, startRow int, endRow int) [][]byte {
-	nextState := make([][]byte, endRow-startRow)
-
-	for i := 0; i < endRow-startRow; i++ {
-		nextState[i] = make([]byte, width)
-	}
-
-	for i := startRow; i < endRow; i++ {
-		for j := 0; j < width; j++ {
-			//sum of neighboring cells around the current one
-			sum := (int(world[(i+height-1)%height][(j+width-1)%width]) +
-				int(world[(i+height-1)%height][(j+width)%width]) +
-				int(world[(i+height-1)%height][(j+width+1)%width]) +
-				int(world[(i+height)%height][(j+width-1)%width]) +
-				int(world[(i+height)%height][(j+width+1)%width]) +
-				int(world[(i+height+1)%height][(j+width-1)%width]) +
-				int(world[(i+height+1)%height][(j+width)%width]) +
-				int(world[(i+height+1)%height][(j+width+1)%width])) / 255
-
-			//if live cell
-			if world[i][j] == 255 {
-				//if less than 2 neighbors then die
-				if sum < 2 {
-					nextState[i-startRow][j] = 0
-				} else if sum == 2 || sum == 3 { //if 2 or 3 neighbors then unaffected
-					nextState[i-startRow][j] = 255
-				} else { //if more than 3 neighbors then die
-					nextState[i-startRow][j] = 0
-				}
-			} else { //if dead cell
-				//if 3 neighbors then alive
-				if sum == 3 {
-					nextState[i-startRow][j] = 255
-				} else { //else unaffected
-					nextState[i-startRow][j] = 0
-				}
+// inRegion reports whether a tile is in the rows asked for, and the columns when they are given.
+func inRegion(req *stubs.WorldReq) func(tiles.Coord) bool {
+	return func(c tiles.Coord) bool {
+		if c.Y < req.StartRow || c.Y >= req.EndRow {
+			return false
+		}
+		return req.StartCol == req.EndCol || (c.X >= req.StartCol && c.X < req.EndCol)
+	}
+}
+
+// calculateTurns works out several turns of a region without asking the broker for the tiles around it in between.
+// The first turn also works out the cells within turns-1 of the region, and each turn after that one fewer, as
+// the outermost cells go wrong without their neighbours. The region itself is still right on the last turn.
+// Changes spread a cell a turn, so over at most a tile's width of turns only the tiles in active can change, and
+// only they are worked out. The world has to hold the tiles around them.
+// It returns the tiles of the region that differ from how they started, and those that changed on the last turn,
+// which are the ones whose neighbours can change next.
+func calculateTurns(world *tiles.World, region func(tiles.Coord) bool, active []tiles.Coord, turns int) ([]*tiles.Tile, []tiles.Coord, []tiles.Coord) {
+	canChange := make(map[tiles.Coord]bool)
+	for _, c := range active {
+		canChange[c] = true
+	}
+	padded := make([]byte, (tiles.Size+2)*(tiles.Size+2))
+	current := world
+	touched := make(map[tiles.Coord]bool)
+	var last []tiles.Coord
+	for turn := 0; turn < turns; turn++ {
+		next := current.Copy()
+		lastChanged := make(map[tiles.Coord]bool)
+		last = nil
+		worked := 0
+		for _, c := range active {
+			within := current.Within(c, region, turns-1-turn)
+			if len(within) == 0 {
+				continue
+			}
+			t := calculateTile(current, c.X, c.Y, padded, within)
+			worked++
+			if !tileChanged(current.Tiles[c], t) {
+				continue
+			}
+			if t == nil {
+				delete(next.Tiles, c)
+			} else {
+				next.Tiles[c] = t
+			}
+			lastChanged[c] = true
+			last = append(last, c)
+			if region(c) {
+				touched[c] = true
+			}
+		}
+		tilesCalculated.Add(float64(worked))
+
+		// Never nil, which would mean working out everything
+		active = []tiles.Coord{}
+		for c := range next.Active(lastChanged) {
+			if canChange[c] {
+				active = append(active, c)
 			}
 		}
+		current = next
 	}
 
-	return nextState
+	var nextState []*tiles.Tile
+	var changed, lastChanged []tiles.Coord
+	for c := range touched {
+		if tileChanged(world.Tiles[c], current.Tiles[c]) {
+			changed = append(changed, c)
+			if t := current.Tiles[c]; t != nil {
+				nextState = append(nextState, t)
+			}
+		}
+	}
+	for _, c := range last {
+		if region(c) {
+			lastChanged = append(lastChanged, c)
+		}
+	}
+	return nextState, changed, lastChanged
+}
+
+// calculateNextState works out the active tiles inside the region and returns the ones that changed.
+// A tile can only change if it or a neighbour changed last turn, so every other tile is skipped. Without a list
+// of active tiles every tile with alive cells in or around it is worked out, which is also how new tiles get
+// allocated as patterns grow on an unbounded world.
+func calculateNextState(world *tiles.World, region func(tiles.Coord) bool, active []tiles.Coord) ([]*tiles.Tile, []tiles.Coord) {
+	if active == nil {
+		for c := range world.Active(nil) {
+			active = append(active, c)
+		}
+	}
+
+	padded := make([]byte, (tiles.Size+2)*(tiles.Size+2))
+	var nextState []*tiles.Tile
+	var changed []tiles.Coord
+	worked := 0
+	for _, c := range active {
+		if !region(c) {
+			continue
+		}
+		t := calculateTile(world, c.X, c.Y, padded, nil)
+		worked++
+		if tileChanged(world.Tiles[c], t) {
+			changed = append(changed, c)
+			if t != nil {
+				nextState = append(nextState, t)
+			}
+		}
+	}
+	tilesCalculated.Add(float64(worked))
+	return nextState, changed
+}
+
+// splitDeltas turns changed tiles into the cells that flipped in them, keeping a tile whole
+// when its flipped cells would take more bytes to send than the tile itself. A tile with nothing
+// left alive needs neither, Changed is enough for the broker to drop it.
+func splitDeltas(world *tiles.World, nextState []*tiles.Tile, changed []tiles.Coord) ([]*tiles.Tile, []util.Cell) {
+	next := make(map[tiles.Coord]*tiles.Tile)
+	for _, t := range nextState {
+		next[tiles.Coord{X: t.X, Y: t.Y}] = t
+	}
+	var whole []*tiles.Tile
+	var flipped []util.Cell
+	for _, c := range changed {
+		cells := tiles.TileFlipped(c, world.Tiles[c], next[c])
+		if len(cells)*8 < tiles.Size*tiles.Size {
+			flipped = append(flipped, cells...)
+		} else if next[c] != nil {
+			whole = append(whole, next[c])
+		}
+	}
+	return whole, flipped
+}
+
+// tileChanged compares two versions of a tile, nil being a tile with nothing alive in it.
+func tileChanged(old *tiles.Tile, new *tiles.Tile) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return !bytes.Equal(old.Cells, new.Cells)
+}
+
+// calculateTile returns the next state of one tile, or nil if nothing in it is alive. When within is given only
+// the cells in it are worked out and the rest are left as they were.
+func calculateTile(world *tiles.World, tx int, ty int, padded []byte, within []tiles.Rect) *tiles.Tile {
+	const stride = tiles.Size + 2
+	x0 := tx * tiles.Size
+	y0 := ty * tiles.Size
+
+	//copy the tile and a one cell border around it as 0s and 1s
+	current := world.Tiles[tiles.Coord{X: tx, Y: ty}]
+	if current != nil && world.InBoard(x0+tiles.Size-1, y0+tiles.Size-1) {
+		for r := 0; r < tiles.Size; r++ {
+			for c := 0; c < tiles.Size; c++ {
+				padded[(r+1)*stride+c+1] = current.Cells[r*tiles.Size+c] & 1
+			}
+		}
+		for i := -1; i <= tiles.Size; i++ {
+			padded[i+1] = world.Get(x0+i, y0-1) & 1
+			padded[(tiles.Size+1)*stride+i+1] = world.Get(x0+i, y0+tiles.Size) & 1
+			padded[(i+1)*stride] = world.Get(x0-1, y0+i) & 1
+			padded[(i+1)*stride+tiles.Size+1] = world.Get(x0+tiles.Size, y0+i) & 1
+		}
+	} else {
+		//tiles on the edge of the board need every neighbour wrapped around the board
+		for r := -1; r <= tiles.Size; r++ {
+			for c := -1; c <= tiles.Size; c++ {
+				padded[(r+1)*stride+c+1] = world.Get(x0+c, y0+r) & 1
+			}
+		}
+	}
+
+	next := tiles.NewTile(tx, ty)
+	if within == nil {
+		within = []tiles.Rect{{X0: 0, Y0: 0, X1: tiles.Size, Y1: tiles.Size}}
+	} else if current != nil {
+		copy(next.Cells, current.Cells)
+	}
+	for _, rect := range within {
+		for r := rect.Y0; r < rect.Y1 && world.InBoard(x0, y0+r); r++ {
+			for c := rect.X0; c < rect.X1 && world.InBoard(x0+c, y0+r); c++ {
+				i := (r+1)*stride + c + 1
+				//sum of neighboring cells around the current one
+				sum := padded[i-stride-1] + padded[i-stride] + padded[i-stride+1] +
+					padded[i-1] + padded[i+1] +
+					padded[i+stride-1] + padded[i+stride] + padded[i+stride+1]
+
+				//a live cell survives with 2 or 3 neighbours and a dead cell with 3 neighbours becomes alive
+				if sum == 3 || (sum == 2 && padded[i] == 1) {
+					next.Cells[r*tiles.Size+c] = 255
+				} else {
+					next.Cells[r*tiles.Size+c] = 0
+				}
+			}
+		}
+	}
+	if bytes.IndexByte(next.Cells, 255) < 0 {
+		return nil
+	}
+	return next
 }
 
 func main() {
 	pAddr := flag.String("port", "8040", "Port to listen on")
+	secure := transport.SecurityFlags()
+	logging.LevelFlag()
 	flag.Parse()
+	logging.SetNode("worker:" + *pAddr)
+
+	err := transport.Configure(*secure)
+	if err != nil {
+		logging.Fatal("could not set up security", "err", err)
+	}
 
 	ops := &WorldOps{}
 	rpc.Register(ops)
@@ -84,10 +346,12 @@ func main() {
 
 	listener, err := net.Listen("tcp", ":"+*pAddr)
 	if err != nil {
-		fmt.Println("Error starting listener:", err)
-		return
+		logging.Fatal("could not listen", "port", *pAddr, "err", err)
 	}
 	defer listener.Close()
-	fmt.Println("Listening on port", *pAddr)
-	rpc.Accept(listener)
+	logging.Info("listening", "port", *pAddr)
+	err = transport.Serve(listener, rpc.DefaultServer)
+	if err != nil {
+		logging.Error("stopped accepting connections", "err", err)
+	}
 }
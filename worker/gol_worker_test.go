@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/tiles"
+)
+
+// step works out one turn of the whole world.
+func step(world *tiles.World) *tiles.World {
+	nextState, changed := calculateNextState(world, func(tiles.Coord) bool { return true }, nil)
+	next := world.Copy()
+	for _, c := range changed {
+		delete(next.Tiles, c)
+	}
+	next.Add(nextState)
+	return next
+}
+
+// TestCalculateTurns works out 2, 5 and 64 turns of the top rows of tiles in one go and checks they come out the
+// same as single turns of the whole board. A glider heads out of the only active tile towards a block two tiles
+// along, which sits across a tile edge and is quiet until the glider wakes the tiles next to it. Another block
+// sits in the first rows below the region, inside even the shallowest halo.
+func TestCalculateTurns(t *testing.T) {
+	world := tiles.New(1024, 1024)
+	for _, cell := range [][2]int{{59, 10}, {60, 11}, {58, 12}, {59, 12}, {60, 12}} {
+		world.Set(cell[0], cell[1], 255)
+	}
+	for _, cell := range [][2]int{{191, 40}, {192, 40}, {191, 41}, {192, 41}, {300, 576}, {301, 576}, {300, 577}, {301, 577}} {
+		world.Set(cell[0], cell[1], 255)
+	}
+	region := func(c tiles.Coord) bool { return c.Y < 9 }
+	ring := func(c tiles.Coord) bool { return c.Y == 9 || c.Y == world.Rows()-1 }
+
+	for _, turns := range []int{2, 5, 64} {
+		t.Run(fmt.Sprintf("%d turns", turns), func(t *testing.T) {
+			// The broker sends the active tiles in the region and next to it, the tiles around those, and only
+			// the cells within turns of the region from the tiles next to it
+			var active []tiles.Coord
+			for c := range world.Active(map[tiles.Coord]bool{{X: 0, Y: 0}: true, {X: 4, Y: 9}: true}) {
+				if region(c) || ring(c) {
+					active = append(active, c)
+				}
+			}
+			sent := tiles.New(world.Width, world.Height)
+			for _, tile := range world.Around(active) {
+				c := tiles.Coord{X: tile.X, Y: tile.Y}
+				if region(c) {
+					sent.Tiles[c] = tile
+				} else if within := world.Within(c, region, turns); len(within) > 0 {
+					if cropped := tile.Crop(within); cropped != nil {
+						sent.Tiles[c] = cropped
+					}
+				}
+			}
+			for y := 9 * tiles.Size; y < world.Height-tiles.Size; y++ {
+				for x := 0; x < world.Width; x++ {
+					if sent.Get(x, y) != 0 && y >= 9*tiles.Size+turns {
+						t.Fatalf("expected only the %d rows next to the region to be sent, got cell (%d, %d)", turns, x, y)
+					}
+				}
+			}
+			nextState, changed, _ := calculateTurns(sent, region, active, turns)
+
+			got := world.Copy()
+			for _, c := range changed {
+				delete(got.Tiles, c)
+			}
+			got.Add(nextState)
+			want := world
+			for turn := 0; turn < turns; turn++ {
+				want = step(want)
+			}
+			for y := 0; y < 9*tiles.Size; y++ {
+				for x := 0; x < world.Width; x++ {
+					if got.Get(x, y) != want.Get(x, y) {
+						t.Errorf("expected cell (%d, %d) to be %d after %d turns, got %d", x, y, want.Get(x, y), turns, got.Get(x, y))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/logging"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/tiles"
+	"uk.ac.bris.cs/gameoflife/transport"
+)
+
+// electTimeout is how long a worker in a ring has to answer before it is left out of the run.
+const electTimeout = time.Second
+
+// peer is a worker's part in a run without a broker: the tile rows it owns and who owns the rows either side,
+// and the protocol to fetch their edges with.
+type peer struct {
+	mu       sync.Mutex
+	session  string
+	world    *tiles.World
+	startRow int
+	endRow   int
+	turn     int
+	above    string
+	below    string
+	protocol string
+	clients  map[string]transport.Client
+	edges    edges
+}
+
+// edges are a peer's first and last tile rows after each of the last two turns, kept apart from peer.mu so
+// neighbours can fetch them while the peer works out its own turn. Turns are only started once every peer has
+// finished the one before, so no neighbour is ever more than a turn behind.
+type edges struct {
+	mu      sync.Mutex
+	session string
+	turns   map[int][2][]*tiles.Tile
+}
+
+// keep records the edges of the world after turn. Called with p.mu held.
+func (p *peer) keep(turn int, world *tiles.World) {
+	p.edges.mu.Lock()
+	defer p.edges.mu.Unlock()
+	if p.edges.session != p.session {
+		p.edges.session = p.session
+		p.edges.turns = make(map[int][2][]*tiles.Tile)
+	}
+	p.edges.turns[turn] = [2][]*tiles.Tile{world.Strip(p.startRow, p.startRow+1), world.Strip(p.endRow-1, p.endRow)}
+	delete(p.edges.turns, turn-2)
+}
+
+// edge fetches the edge of a neighbour's rows after the turn this peer is on. Called with p.mu held.
+func (p *peer) edge(address string, top bool) ([]*tiles.Tile, error) {
+	client := p.clients[address]
+	if client == nil {
+		var err error
+		client, err = transport.Dial(p.protocol, address)
+		if err != nil {
+			return nil, err
+		}
+		p.clients[address] = client
+	}
+	res := &stubs.EdgeResponse{}
+	err := client.Call(stubs.EdgeHandler, stubs.EdgeRequest{Session: p.session, Turn: p.turn, Top: top}, res)
+	if err != nil {
+		client.Close()
+		delete(p.clients, address)
+		return nil, err
+	}
+	return res.Tiles, nil
+}
+
+// leading is how far the run a leader is coordinating has got.
+type leading struct {
+	mu     sync.Mutex
+	status stubs.PeerStatusResponse
+}
+
+func (l *leading) record(turn int, alive int, evolving bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = stubs.PeerStatusResponse{Turn: turn, Alive: alive, Evolving: evolving}
+}
+
+// answering dials a worker with protocol and sends it a heartbeat, returning the connection if it answered in time.
+func answering(protocol string, address string) transport.Client {
+	answered := make(chan transport.Client, 1)
+	go func() {
+		client, err := transport.Dial(protocol, address)
+		if err == nil {
+			err = client.Call(stubs.HeartbeatHandler, stubs.Empty{}, &stubs.Empty{})
+			if err != nil {
+				client.Close()
+			}
+		}
+		if err != nil {
+			client = nil
+		}
+		answered <- client
+	}()
+	select {
+	case client := <-answered:
+		return client
+	case <-time.After(electTimeout):
+		return nil
+	}
+}
+
+// elect returns the workers in the ring that answer, in ring order, along with connections to them. The first
+// of them is the leader.
+func elect(protocol string, ring []string) ([]string, []transport.Client) {
+	answered := make([]transport.Client, len(ring))
+	var wg sync.WaitGroup
+	for i, address := range ring {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			answered[i] = answering(protocol, address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	var members []string
+	var clients []transport.Client
+	for i, client := range answered {
+		if client != nil {
+			members = append(members, ring[i])
+			clients = append(clients, client)
+		}
+	}
+	return members, clients
+}
+
+// each calls f for every client at once, returning the first error.
+func each(clients []transport.Client, f func(i int, client transport.Client) error) error {
+	errs := make(chan error, len(clients))
+	for i, client := range clients {
+		go func(i int, client transport.Client) {
+			errs <- f(i, client)
+		}(i, client)
+	}
+	var first error
+	for range clients {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Elect tells the controller which worker in a ring leads a run.
+func (w *WorldOps) Elect(req *stubs.ElectRequest, res *stubs.ElectResponse) (err error) {
+	if err = req.Check(stubs.Operator, "run peers"); err != nil {
+		return
+	}
+	members, clients := elect(req.Transport, req.Ring)
+	for _, client := range clients {
+		client.Close()
+	}
+	if len(members) == 0 {
+		return errors.New("no worker in the ring answered")
+	}
+	res.Leader = members[0]
+	return
+}
+
+// RunPeers leads a run without a broker. Every worker in the ring that answers is given a strip of rows, then
+// each turn is started on all of them at once, once all of them have finished the last. The workers fetch the
+// rows along their edges from each other, so the world only goes through the leader at the start and the end.
+func (w *WorldOps) RunPeers(req *stubs.PeerRunRequest, res *stubs.PeerRunResponse) (err error) {
+	if err = req.Check(stubs.Operator, "run peers"); err != nil {
+		return
+	}
+	members, clients := elect(req.Transport, req.Ring)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	if len(members) == 0 {
+		return errors.New("no worker in the ring answered")
+	}
+	world := tiles.FromList(req.Width, req.Height, req.Tiles)
+	rows := world.Rows()
+	// Every worker needs at least a tile row
+	if len(members) > rows {
+		members = members[:rows]
+	}
+	n := len(members)
+	logging.Info("leading run", "session", req.Session, "peers", members, "turns", req.Turns)
+
+	w.leading.record(0, world.AliveCount(), true)
+	err = each(clients[:n], func(i int, client transport.Client) error {
+		own := stubs.OwnRequest{
+			Session:   req.Session,
+			Tiles:     world.Strip(i*rows/n, (i+1)*rows/n),
+			Width:     req.Width,
+			Height:    req.Height,
+			StartRow:  i * rows / n,
+			EndRow:    (i + 1) * rows / n,
+			Transport: req.Transport,
+		}
+		// A lone worker has the whole board and wraps around onto itself
+		if n > 1 {
+			own.Above = members[(i+n-1)%n]
+			own.Below = members[(i+1)%n]
+		}
+		return client.Call(stubs.OwnHandler, own, &stubs.Empty{})
+	})
+
+	for turn := 1; turn <= req.Turns && err == nil; turn++ {
+		alive := make([]int, n)
+		err = each(clients[:n], func(i int, client transport.Client) error {
+			step := &stubs.StepResponse{}
+			err := client.Call(stubs.StepHandler, stubs.StepRequest{Session: req.Session, Turn: turn}, step)
+			alive[i] = step.Alive
+			return err
+		})
+		total := 0
+		for _, a := range alive {
+			total += a
+		}
+		w.leading.record(turn, total, true)
+	}
+	if err != nil {
+		w.leading.record(0, 0, false)
+		logging.Error("peer run failed", "session", req.Session, "err", err)
+		return
+	}
+
+	parts := make([][]*tiles.Tile, n)
+	err = each(clients[:n], func(i int, client transport.Client) error {
+		collected := &stubs.CollectResponse{}
+		err := client.Call(stubs.CollectHandler, stubs.CollectRequest{Session: req.Session}, collected)
+		parts[i] = collected.Tiles
+		return err
+	})
+	if err != nil {
+		w.leading.record(0, 0, false)
+		return
+	}
+	final := tiles.New(req.Width, req.Height)
+	for _, part := range parts {
+		final.Add(part)
+	}
+	w.leading.record(req.Turns, final.AliveCount(), false)
+	res.Tiles = final.List()
+	res.Turn = req.Turns
+	return
+}
+
+// PeerStatus reports how far the run this worker is leading has got.
+func (w *WorldOps) PeerStatus(req *stubs.Empty, res *stubs.PeerStatusResponse) (err error) {
+	if err = req.Check(stubs.Observer, "count alive cells"); err != nil {
+		return
+	}
+	w.leading.mu.Lock()
+	defer w.leading.mu.Unlock()
+	*res = w.leading.status
+	return
+}
+
+// Own hands this worker its rows of a run without a broker.
+func (w *WorldOps) Own(req *stubs.OwnRequest, res *stubs.Empty) (err error) {
+	if err = req.Check(stubs.Operator, "run peers"); err != nil {
+		return
+	}
+	p := &w.peer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, client := range p.clients {
+		client.Close()
+	}
+	p.clients = make(map[string]transport.Client)
+	p.session = req.Session
+	p.world = tiles.FromList(req.Width, req.Height, req.Tiles)
+	p.startRow, p.endRow = req.StartRow, req.EndRow
+	p.turn = req.Turn
+	p.above, p.below = req.Above, req.Below
+	p.protocol = req.Transport
+	p.keep(p.turn, p.world)
+	logging.Info("owning rows", "session", req.Session, "startRow", req.StartRow, "endRow", req.EndRow,
+		"above", req.Above, "below", req.Below)
+	return
+}
+
+// Step works out the next turn of this worker's rows, fetching the rows along their edges from its neighbours.
+func (w *WorldOps) Step(req *stubs.StepRequest, res *stubs.StepResponse) (err error) {
+	if err = req.Check(stubs.Operator, "run peers"); err != nil {
+		return
+	}
+	p := &w.peer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req.Session != p.session || req.Turn != p.turn+1 {
+		return fmt.Errorf("asked for turn %d of session %s, this worker is on turn %d of %s", req.Turn, req.Session, p.turn, p.session)
+	}
+	start := time.Now()
+
+	world := p.world.Copy()
+	for _, neighbour := range []struct {
+		address string
+		top     bool
+	}{{p.above, false}, {p.below, true}} {
+		if neighbour.address == "" {
+			continue
+		}
+		halo, err := p.edge(neighbour.address, neighbour.top)
+		if err != nil {
+			return fmt.Errorf("could not fetch the edge from %s: %v", neighbour.address, err)
+		}
+		world.Add(halo)
+	}
+
+	nextState, changed := calculateNextState(world, func(c tiles.Coord) bool {
+		return c.Y >= p.startRow && c.Y < p.endRow
+	}, nil)
+	next := p.world.Copy()
+	for _, c := range changed {
+		delete(next.Tiles, c)
+	}
+	next.Add(nextState)
+	p.world = next
+	p.turn = req.Turn
+	p.keep(p.turn, p.world)
+	res.Alive = next.AliveCount()
+	logging.Debug("rows calculated", "session", req.Session, "turn", req.Turn, "startRow", p.startRow, "endRow", p.endRow,
+		"changed", len(changed), "took", time.Since(start))
+	return
+}
+
+// Edge hands a neighbour the first or last tile row of this worker's rows.
+func (w *WorldOps) Edge(req *stubs.EdgeRequest, res *stubs.EdgeResponse) (err error) {
+	if err = req.Check(stubs.Observer, "fetch edges"); err != nil {
+		return
+	}
+	e := &w.peer.edges
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	turn, ok := e.turns[req.Turn]
+	if req.Session != e.session || !ok {
+		return fmt.Errorf("no edge kept for turn %d of session %s", req.Turn, req.Session)
+	}
+	if req.Top {
+		res.Tiles = turn[0]
+	} else {
+		res.Tiles = turn[1]
+	}
+	return
+}
+
+// Collect hands the leader this worker's rows once the run is over.
+func (w *WorldOps) Collect(req *stubs.CollectRequest, res *stubs.CollectResponse) (err error) {
+	if err = req.Check(stubs.Observer, "fetch the board"); err != nil {
+		return
+	}
+	p := &w.peer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req.Session != p.session {
+		return fmt.Errorf("not running session %s", req.Session)
+	}
+	res.Tiles = p.world.List()
+	res.Turn = p.turn
+	return
+}